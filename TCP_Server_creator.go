@@ -1,6 +1,8 @@
 package networktools
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
@@ -10,97 +12,224 @@ import (
 // Creates a TCP listener that forwards all requests to a given port on the request channel.
 // The request channel is a collection of TCPNetworkData onjects defined clearly in the standards file.
 // The function will return the request channel and a TCP listener object that represents the TCP listener routeine. To stop listening on the TCP port use the Stop command.
+// The port is bound before the function returns, so an error means nothing is listening, for example because the port is already in use.
+// Pass port 0 to have the system pick a free port, listener.Addr() tells you which.
+// The listener can be customised with ServerOptions, see server_config.go for what can be changed.
 //
 // Example Usage:
 //
-//	request_channel, listener := Create_TCP_listener(8080)
+//	request_channel, listener, err := Create_TCP_listener(8080)
+//	if err != nil {
+//		return err
+//	}
 //	(code code code)
 //	listener.Stop (When you're done)
-func Create_TCP_Listener(port uint16) (chan TCPNetworkData, *TCPListener) {
+//
+//	// Only reachable from this machine, over IPv4
+//	request_channel, listener, err := Create_TCP_listener(8080, networktools.WithHost("127.0.0.1"), networktools.WithIPVersion(networktools.IPv4))
+func Create_TCP_Listener(port uint16, opts ...ServerOption) (chan TCPNetworkData, *TCPListener, error) {
+	cfg := newServerConfig(opts)
+
+	request_channel := make(chan TCPNetworkData, cfg.ChannelBuffer)
+	tcpListener := newTCPListener(func() {
+		close(request_channel)
+	})
 
-	request_channel := make(chan TCPNetworkData)
-	tcpListener := &TCPListener{
-		StopCh: make(chan struct{}),
+	err := listen_tcp(port, cfg, tcpListener, func(data TCPNetworkData) {
+		select {
+		case request_channel <- data:
+		case <-tcpListener.StopCh:
+			// Nobody may be reading any more, so don't wait for them.
+		}
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	go listen_tcp(port, request_channel, tcpListener)
+	return request_channel, tcpListener, nil
+}
 
-	return request_channel, tcpListener
+// Create_TCP_Listener_With_Max_Size works the same as Create_TCP_Listener but lets you choose the largest request the listener will accept.
+// It is the same as passing WithMaxMessageSize to Create_TCP_Listener.
+//
+// Example Usage:
+//
+//	// Accept camera frames of up to 256MB
+//	request_channel, listener, err := Create_TCP_Listener_With_Max_Size(8080, 256<<20)
+//	(code code code)
+//	listener.Stop (When you're done)
+func Create_TCP_Listener_With_Max_Size(port uint16, max_message_size uint32, opts ...ServerOption) (chan TCPNetworkData, *TCPListener, error) {
+	return Create_TCP_Listener(port, append(opts, WithMaxMessageSize(max_message_size))...)
 }
 
-func listen_tcp(port uint16, request_channel chan TCPNetworkData, tcpListener *TCPListener) {
-	addr := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", addr)
+// Create_TCP_Listener_With_Router creates a TCP listener that hands every request straight to the router instead of a channel.
+// Whatever the matching handler returns is written back on the same connection with SendTCPReply.
+// Requests on a single connection are handled one at a time and in order, separate connections are handled concurrently.
+//
+// Example Usage:
+//
+//	router := networktools.NewRouter()
+//	router.Handle(RequestCamera, handleCamera)
+//	listener, err := Create_TCP_Listener_With_Router(8080, router)
+//	(code code code)
+//	listener.Stop (When you're done)
+func Create_TCP_Listener_With_Router(port uint16, router *Router, opts ...ServerOption) (*TCPListener, error) {
+	cfg := newServerConfig(opts)
+
+	tcpListener := newTCPListener(nil)
+	err := listen_tcp(port, cfg, tcpListener, func(data TCPNetworkData) {
+		router.serveTCP(data, cfg, tcpListener.tracker)
+	})
 	if err != nil {
-		fmt.Println("Error listening:", err)
-		return
+		return nil, err
 	}
-	tcpListener.Listener = listener
-	defer listener.Close()
 
-	publicIP, err := GetPublicIP()
-	if err != nil {
-		fmt.Println("Error getting public IP:", err)
+	return tcpListener, nil
+}
+
+func newTCPListener(onDrained func()) *TCPListener {
+	return &TCPListener{
+		StopCh:  make(chan struct{}),
+		tracker: newTracker(onDrained),
 	}
+}
 
-	localIP, err := GetLocalIP()
+// listen_tcp binds the listening socket before returning, so it is in place by the time the constructor hands the listener back.
+func listen_tcp(port uint16, cfg ServerConfig, tcpListener *TCPListener, handle func(TCPNetworkData)) error {
+	listener, err := net.Listen(cfg.IPVersion.network("tcp"), cfg.address(port))
 	if err != nil {
-		fmt.Println("Error getting local IP address:", err)
+		cfg.Logger.Error("Error listening", "port", port, "error", err)
+		return fmt.Errorf("error listening on port %d: %w", port, err)
 	}
+	tcpListener.Listener = listener
+	tcpListener.stats = cfg.stats
 
-	fmt.Printf("TCP server listening on port %d\n", port)
-	fmt.Printf("Server Global IP is - %s\n", publicIP)
-	fmt.Printf("Server Local IP is - %s\n", localIP)
+	go cfg.announce("TCP", listener.Addr())
+
+	tcpListener.tracker.wg.Add(1)
+	go accept_tcp(listener, cfg, tcpListener, handle)
+
+	return nil
+}
+
+func accept_tcp(listener net.Listener, cfg ServerConfig, tcpListener *TCPListener, handle func(TCPNetworkData)) {
+	defer tcpListener.tracker.wg.Done()
+	defer listener.Close()
 
 	for {
 		select {
 		case <-tcpListener.StopCh:
 			return
 		default:
-			listener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second))
+			listener.(*net.TCPListener).SetDeadline(time.Now().Add(cfg.PollInterval))
 			conn, err := listener.Accept()
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue
 				}
-				fmt.Println("Error accepting connection:", err)
+				if tcpListener.tracker.isStopping() {
+					return
+				}
+				cfg.Logger.Error("Error accepting connection", "error", err)
+				cfg.report("accept", nil, err)
 				continue
 			}
-			go handleTCPConnection(conn, request_channel)
+			if cfg.TLSConfig != nil {
+				conn = tls.Server(conn, cfg.TLSConfig)
+			}
+			if !tcpListener.tracker.addConn(conn) {
+				conn.Close()
+				return
+			}
+			go handleTCPConnection(conn, cfg, tcpListener.tracker, handle)
 		}
 	}
 }
 
-func handleTCPConnection(conn net.Conn, request_channel chan TCPNetworkData) {
-	buffer := make([]byte, 1424) // Arbitrary buffer size
+func handleTCPConnection(conn net.Conn, cfg ServerConfig, tracker *tracker, handle func(TCPNetworkData)) {
+	defer tracker.removeConn(conn)
 
-	for {
-		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-		n, err := conn.Read(buffer)
+	// The connection counts as idle during the handshakes, as no request has been read yet, so stopping the listener cuts them short.
+	var peer *x509.Certificate
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if !tracker.awaitRequest(conn, deadline(cfg.ReadTimeout)) {
+			return
+		}
+		var err error
+		peer, err = acceptTLS(tlsConn, cfg)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
+			if tracker.isStopping() {
+				return
 			}
-			if err == io.EOF {
-				conn.Close()
+			cfg.Logger.Warn("TLS handshake failed", "remote", conn.RemoteAddr(), "error", err)
+			cfg.report("handshake", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	var hello Hello
+	if cfg.Handshake != nil {
+		if !tracker.awaitRequest(conn, deadline(cfg.ReadTimeout)) {
+			return
+		}
+		var err error
+		hello, err = acceptHello(conn, cfg)
+		if err != nil {
+			if tracker.isStopping() {
+				return
+			}
+			cfg.Logger.Warn("Handshake failed", "remote", conn.RemoteAddr(), "error", err)
+			cfg.report("handshake", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	var header [frameHeaderSize]byte
+
+	for {
+		// Once the listener is stopping no new requests are read, the one being handled was the last.
+		if !tracker.awaitRequest(conn, deadline(cfg.IdleTimeout)) {
+			return
+		}
+
+		_, err := io.ReadFull(conn, header[:])
+		if err != nil {
+			if err == io.EOF || tracker.isStopping() {
 				return
 				// We assume the client has closed the connection
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				cfg.Logger.Debug("Closing idle connection", "remote", conn.RemoteAddr())
 			} else {
-				fmt.Println("Error reading from connection:", err)
+				cfg.Logger.Warn("Error reading from connection", "remote", conn.RemoteAddr(), "error", err)
+				cfg.report("read", conn.RemoteAddr(), err)
 			}
-			conn.Close()
 			return
 		}
 
-		req, err := DeserialiseRequest(buffer[:n])
+		// Once a frame has started it has to be read in full, otherwise the stream can no longer be trusted.
+		tracker.busy(conn)
+		conn.SetReadDeadline(deadline(cfg.ReadTimeout))
+		data, err := readFrameBody(conn, header, cfg.MaxMessageSize)
+		if err != nil {
+			cfg.Logger.Warn("Error reading from connection", "remote", conn.RemoteAddr(), "error", err)
+			cfg.report("read", conn.RemoteAddr(), err)
+			return
+		}
+
+		req, err := deserialiseRequest(data, cfg.MaxMessageSize)
 		if err != nil {
-			fmt.Println("Error deserialising request:", err)
+			cfg.Logger.Warn("Error deserialising request", "remote", conn.RemoteAddr(), "bytes", len(data), "error", err)
+			cfg.report("deserialise", conn.RemoteAddr(), err)
 			continue
 		}
 
-		request_channel <- TCPNetworkData{
+		cfg.Logger.Debug("Received request", "remote", conn.RemoteAddr(), "type", req.Type, "bytes", len(data))
+		cfg.stats.received()
+		handle(TCPNetworkData{
 			Request: req,
 			Conn:    conn,
-		}
+			Peer:    peer,
+			Hello:   hello,
+		})
 	}
 }
@@ -1,94 +1,274 @@
 package networktools
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
+// maxUDPDatagramSize is the largest payload a single UDP datagram can carry.
+const maxUDPDatagramSize = 65535
+
+// udpReadBuffer is the socket receive buffer asked for on UDP sockets that read requests or replies.
+// A fragmented request arrives as a burst of datagrams, which the default buffer can be too small to hold. The system may cap it lower.
+const udpReadBuffer = 4 << 20
+
 type UDPListener struct {
 	StopCh chan struct{}
+
+	conn     *net.UDPConn
+	stopOnce sync.Once
+	tracker  *tracker
+	reliable *reliableReceiver
+	stats    *listenerStats
+}
+
+// Addr returns the address the listener is bound to.
+// It is how you find out which port was picked when the listener was created with port 0.
+func (l *UDPListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
 }
 
 // Method to stop the listener
+// It stops reading requests and lets the handlers already running finish, without waiting for them. Use Shutdown to wait.
 func (l *UDPListener) Stop() {
-	close(l.StopCh)
+	l.stopOnce.Do(func() {
+		close(l.StopCh)
+		// Wake the read loop up straight away rather than at its next poll.
+		l.conn.SetReadDeadline(time.Now())
+		l.tracker.stop()
+	})
+}
+
+// Shutdown stops the listener and waits for the handlers already running to finish, then closes the socket and the request channel.
+// If the context ends first the handlers' contexts are cancelled and a *ShutdownError says how many were still running.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	if err := listener.Shutdown(ctx); err != nil {
+//		fmt.Println("Listener did not shut down cleanly:", err)
+//	}
+func (l *UDPListener) Shutdown(ctx context.Context) error {
+	l.Stop()
+	return l.tracker.wait(ctx)
 }
 
 // Creates a UDP listener that forwards all requests to a given port to the request channel.
 // The request channel is a collection of UDPNetworkData objects defined clearly in the standards file.
 // The function will return a UDP listener object that represents the UDP listener routine.
 // To stop listening on the UDP port use the Stop command.
+// The port is bound before the function returns, so an error means nothing is listening, for example because the port is already in use.
+// Pass port 0 to have the system pick a free port, listener.Addr() tells you which.
+// The listener can be customised with ServerOptions, see server_config.go for what can be changed.
 //
 // Example usage:
 //
-//	requestChannel := make(chan networktools.UDPNetworkData)
-//	listener := Create_UDP_listener(8080, requestChannel)
+//	requestChannel, listener, err := Create_UDP_listener(8080)
+//	if err != nil {
+//		return err
+//	}
 //	(code code code)
 //	listener.Stop (when you're done with the listener)
-func Create_UDP_Listener(port uint16) (chan UDPNetworkData, *UDPListener) {
-	request_channel := make(chan UDPNetworkData)
-	listener := &UDPListener{
-		StopCh: make(chan struct{}),
-	}
+func Create_UDP_Listener(port uint16, opts ...ServerOption) (chan UDPNetworkData, *UDPListener, error) {
+	cfg := newServerConfig(opts)
 
-	go listen(port, request_channel, listener.StopCh)
+	request_channel := make(chan UDPNetworkData, cfg.ChannelBuffer)
+	listener := newUDPListener(func() {
+		close(request_channel)
+	})
 
-	return request_channel, listener
-}
-
-func listen(port uint16, request_channel chan UDPNetworkData, stopCh chan struct{}) {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
+	err := listen(port, cfg, listener, func(data UDPNetworkData) {
+		select {
+		case request_channel <- data:
+		case <-listener.StopCh:
+			// Nobody may be reading any more, so don't wait for them.
+		}
+	})
 	if err != nil {
-		fmt.Println("Error resolving address:", err)
-		return
+		return nil, nil, err
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	return request_channel, listener, nil
+}
+
+// Create_UDP_Listener_With_Router creates a UDP listener that hands every request straight to the router instead of a channel.
+// Whatever the matching handler returns is sent back to the address the request came from, using the listener's own socket.
+// Each datagram is handled in its own goroutine, so a slow handler does not hold up the rest.
+// The exception is requests from a ReliableUDPSender, which are handled one at a time per sender so they are seen in the order they were sent.
+//
+// Example usage:
+//
+//	router := networktools.NewRouter()
+//	router.Handle(RequestCamera, handleCamera)
+//	listener, err := Create_UDP_Listener_With_Router(8080, router)
+//	(code code code)
+//	listener.Stop (when you're done with the listener)
+func Create_UDP_Listener_With_Router(port uint16, router *Router, opts ...ServerOption) (*UDPListener, error) {
+	cfg := newServerConfig(opts)
+
+	listener := newUDPListener(nil)
+	queues := newPeerQueues()
+	err := listen(port, cfg, listener, func(data UDPNetworkData) {
+		listener.tracker.startHandler(nil)
+		serve := func() {
+			defer listener.tracker.finishHandler(nil)
+			router.serveUDP(listener.tracker.ctx, data, cfg)
+		}
+		if data.Request.Sequence != 0 {
+			queues.run(data.Addr, serve)
+		} else {
+			go serve()
+		}
+	})
 	if err != nil {
-		fmt.Println("Error listening:", err)
-		return
+		return nil, err
 	}
-	defer conn.Close()
 
-	buffer := make([]byte, 1424)
+	return listener, nil
+}
 
-	publicIP, err := GetPublicIP()
+// newUDPListener creates a listener that closes its socket, and then calls onDrained, once it has stopped and every handler has finished.
+func newUDPListener(onDrained func()) *UDPListener {
+	listener := &UDPListener{
+		StopCh:   make(chan struct{}),
+		reliable: newReliableReceiver(),
+	}
+	listener.tracker = newTracker(func() {
+		listener.conn.Close()
+		if onDrained != nil {
+			onDrained()
+		}
+	})
+	return listener
+}
+
+// listen binds the socket before returning, so it is in place by the time the constructor hands the listener back.
+func listen(port uint16, cfg ServerConfig, listener *UDPListener, handle func(UDPNetworkData)) error {
+	network := cfg.IPVersion.network("udp")
+	addr, err := net.ResolveUDPAddr(network, cfg.address(port))
 	if err != nil {
-		fmt.Println("Error getting public IP:", err)
+		cfg.Logger.Error("Error resolving address", "port", port, "error", err)
+		return fmt.Errorf("error resolving address: %w", err)
 	}
 
-	localIP, err := GetLocalIP()
+	conn, err := net.ListenUDP(network, addr)
 	if err != nil {
-		fmt.Println("Error getting local IP address:", err)
+		cfg.Logger.Error("Error listening", "port", port, "error", err)
+		return fmt.Errorf("error listening on port %d: %w", port, err)
 	}
+	listener.conn = conn
+	listener.stats = cfg.stats
+	conn.SetReadBuffer(udpReadBuffer)
+
+	go cfg.announce("UDP", conn.LocalAddr())
+
+	listener.tracker.wg.Add(1)
+	go serve_udp(conn, cfg, listener, handle)
+
+	return nil
+}
+
+// serve_udp reads requests until the listener is stopped. The socket is left open for the handlers still replying and closed once they finish.
+// Sealed datagrams are opened, fragmented requests are put back together, and reliable requests acknowledged and put back in order, before they are handed on.
+func serve_udp(conn *net.UDPConn, cfg ServerConfig, listener *UDPListener, handle func(UDPNetworkData)) {
+	defer listener.tracker.wg.Done()
 
-	fmt.Printf("UDP server listening on port %d\n", port)
-	fmt.Printf("Server Global IP is - %s\n", publicIP)
-	fmt.Printf("Server Local IP is - %s\n", localIP)
+	// Large enough for the biggest possible UDP datagram so nothing is truncated.
+	buffer := make([]byte, maxUDPDatagramSize)
+	fragments := newReassembler(cfg.FragmentTimeout, cfg.MaxFragmentMemory, cfg.MaxMessageSize)
+	var lastSweep time.Time
 
 	for {
 		select {
-		case <-stopCh:
+		case <-listener.StopCh:
 			return
 		default:
-			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if now := time.Now(); now.Sub(lastSweep) >= cfg.PollInterval {
+				lastSweep = now
+				if dropped := fragments.sweep(now); dropped > 0 {
+					cfg.Logger.Warn("Dropping fragmented requests that were never completed", "requests", dropped, "timeout", cfg.FragmentTimeout)
+					cfg.report("read", nil, fmt.Errorf("%d fragmented requests timed out", dropped))
+				}
+				for _, data := range listener.reliable.sweep(cfg, now) {
+					handle(data)
+				}
+			}
+
+			conn.SetReadDeadline(time.Now().Add(cfg.PollInterval))
 			n, remoteAddr, err := conn.ReadFromUDP(buffer)
 			if err != nil {
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					continue
 				}
-				fmt.Println("Error reading from UDP:", err)
+				if listener.tracker.isStopping() {
+					return
+				}
+				cfg.Logger.Warn("Error reading from UDP", "error", err)
+				cfg.report("read", nil, err)
 				continue
 			}
 
-			req, err := DeserialiseRequest(buffer[:n])
+			if overLimit(uint64(n), cfg.MaxMessageSize) {
+				cfg.Logger.Warn("Dropping datagram larger than the limit", "remote", remoteAddr, "bytes", n, "limit", cfg.MaxMessageSize)
+				cfg.report("read", remoteAddr, fmt.Errorf("datagram of %d bytes exceeds the limit of %d bytes", n, cfg.MaxMessageSize))
+				continue
+			}
+
+			datagram := buffer[:n]
+			if cfg.SealKeys != nil {
+				datagram, err = cfg.SealKeys.Open(datagram)
+				if err != nil {
+					cfg.Logger.Warn("Dropping datagram that could not be unsealed", "remote", remoteAddr, "bytes", n, "error", err)
+					cfg.report("unseal", remoteAddr, err)
+					continue
+				}
+			}
+
+			req, err := deserialiseRequest(datagram, cfg.MaxMessageSize)
 			if err != nil {
-				fmt.Println("Error deserialising request:", err)
+				cfg.Logger.Warn("Error deserialising request", "remote", remoteAddr, "bytes", n, "error", err)
+				cfg.report("deserialise", remoteAddr, err)
 				continue
 			}
 
-			request_channel <- UDPNetworkData{Request: req, Addr: remoteAddr}
+			if req.fragmentCount != 0 {
+				whole, err := fragments.add(remoteAddr, req, time.Now())
+				if err != nil {
+					cfg.Logger.Warn("Dropping fragmented request", "remote", remoteAddr, "error", err)
+					cfg.report("read", remoteAddr, err)
+					continue
+				}
+				if whole == nil {
+					// Still waiting for the rest of the fragments.
+					continue
+				}
+
+				n = len(whole)
+				req, err = deserialiseRequest(whole, cfg.MaxMessageSize)
+				if err != nil {
+					cfg.Logger.Warn("Error deserialising request", "remote", remoteAddr, "bytes", n, "error", err)
+					cfg.report("deserialise", remoteAddr, err)
+					continue
+				}
+			}
+
+			cfg.Logger.Debug("Received request", "remote", remoteAddr, "type", req.Type, "bytes", n)
+			cfg.stats.received()
+			data := UDPNetworkData{Request: req, Addr: remoteAddr, conn: conn, keys: cfg.SealKeys}
+			switch {
+			case req.Type == RequestAck:
+				// Acknowledgements are meant for senders, not listeners.
+			case req.Sequence != 0:
+				for _, data := range listener.reliable.receive(data, cfg, time.Now()) {
+					handle(data)
+				}
+			default:
+				handle(data)
+			}
 		}
 	}
 }
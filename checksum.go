@@ -0,0 +1,36 @@
+package networktools
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+)
+
+// ErrChecksumMismatch is returned by DeserialiseRequest for a request whose payload doesn't match its checksum, meaning it was corrupted on the way.
+// Listeners drop such requests and count them in ListenerStats.ChecksumFailures.
+var ErrChecksumMismatch = errors.New("payload does not match its checksum")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// addChecksum sets the checksum of the request's payload, once the payload is in the form it will be sent in.
+func addChecksum(req *pb.Request) {
+	if len(req.Payload) == 0 {
+		return
+	}
+	checksum := crc32.Checksum(req.Payload, castagnoli)
+	req.Checksum = &checksum
+}
+
+// verifyChecksum checks a received request's payload against its checksum, if it has one.
+// Requests from senders that don't checksum their payloads are let through as they are.
+func verifyChecksum(req *pb.Request) error {
+	if req.Checksum == nil {
+		return nil
+	}
+	if checksum := crc32.Checksum(req.Payload, castagnoli); checksum != *req.Checksum {
+		return fmt.Errorf("%w: payload has checksum %08x instead of %08x", ErrChecksumMismatch, checksum, *req.Checksum)
+	}
+	return nil
+}
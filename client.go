@@ -0,0 +1,206 @@
+package networktools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrClientClosed is returned by Client.Call once the client has been closed or its connection has failed.
+var ErrClientClosed = errors.New("client closed")
+
+// Client keeps a single TCP connection open and lets many goroutines make calls over it at the same time.
+// Each call is tagged with a correlation ID and the reply carrying the same ID is handed back to the caller that made it, so the server is free to reply in any order.
+// The server has to copy the ID onto its replies, which the Router does automatically and CorrelateReply does for hand written replies.
+//
+// Example:
+//
+//	client, err := networktools.Dial_Client("192.168.1.76:5057")
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	reply, err := client.Call(ctx, req)
+//	if err != nil {
+//		return err
+//	}
+//	var c Camera
+//	err = networktools.DeserialiseData(&c, reply.Payload)
+type Client struct {
+	conn   net.Conn
+	nextID uint64
+
+	writing chan struct{} // Holds a value while a call is sending, so each call's write deadline only applies to its own request
+
+	mu      sync.Mutex
+	pending map[uint64]chan Request_Type
+
+	*closer
+}
+
+// Dial_Client opens a connection to the target address and returns a Client that makes calls over it.
+// Be aware you will have to close the client yourself.
+func Dial_Client(target_address string) (*Client, error) {
+	return Dial_Client_Context(context.Background(), target_address)
+}
+
+// Dial_Client_Context works the same as Dial_Client but gives up dialing once the context is cancelled or its deadline passes.
+// The context only bounds dialing, each call is bounded by the context given to Call.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+//	defer cancel()
+//	client, err := networktools.Dial_Client_Context(ctx, "192.168.1.76:5057")
+func Dial_Client_Context(ctx context.Context, target_address string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", target_address)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing TCP: %w", err)
+	}
+
+	return NewClient(conn), nil
+}
+
+// NewClient wraps an existing connection in a Client. The client takes ownership of the connection and closes it in Close.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		writing: make(chan struct{}, 1),
+		pending: make(map[uint64]chan Request_Type),
+		closer:  newCloser(),
+	}
+	go c.readReplies()
+	return c
+}
+
+// Call sends a request generated with GenerateRequest and waits for its reply.
+// It returns early with the context's error if the context is cancelled or its deadline passes first, in which case a late reply is discarded.
+// The context bounds sending the request as well as waiting for the reply. Sending can't be abandoned part way through without garbling the connection, so the client is closed if it is.
+// Headers carried by the context are added to the request, see ContextWithHeaders.
+// An error reply is returned as a *RemoteError.
+func (c *Client) Call(ctx context.Context, data []byte) (Request_Type, error) {
+	id := atomic.AddUint64(&c.nextID, 1)
+	replyCh := make(chan Request_Type, 1)
+
+	if err := c.failed(); err != nil {
+		return Request_Type{}, err
+	}
+	c.mu.Lock()
+	c.pending[id] = replyCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, setCorrelationID(addHeaders(data, ContextHeaders(ctx)), id)); err != nil {
+		return Request_Type{}, fmt.Errorf("error sending request: %w", err)
+	}
+
+	select {
+	case reply := <-replyCh:
+		if err := ReplyError(reply); err != nil {
+			return Request_Type{}, err
+		}
+		return reply, nil
+	case <-ctx.Done():
+		return Request_Type{}, ctx.Err()
+	case <-c.closed:
+		return Request_Type{}, c.failed()
+	}
+}
+
+// write sends a request, waiting its turn behind calls already sending, within the context.
+func (c *Client) write(ctx context.Context, data []byte) error {
+	select {
+	case c.writing <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return c.failed()
+	}
+	defer func() { <-c.writing }()
+
+	stop := watchWrites(ctx, c.conn)
+	defer stop()
+
+	if err := WriteFrame(c.conn, data); err != nil {
+		// Part of the request may have been sent, after which nothing else sent on the connection would make sense to the server.
+		c.fail(fmt.Errorf("%w: %v", ErrClientClosed, err))
+		c.conn.Close()
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// Close closes the connection and fails every call that is still waiting for a reply with ErrClientClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClientClosed)
+	return c.conn.Close()
+}
+
+func (c *Client) readReplies() {
+	for {
+		data, err := readFrame(c.conn, DefaultMaxMessageSize)
+		if err != nil {
+			c.fail(fmt.Errorf("%w: %v", ErrClientClosed, err))
+			c.conn.Close()
+			return
+		}
+
+		reply, err := DeserialiseRequest(data)
+		if err != nil {
+			defaultLogger().Warn("Error deserialising reply", "remote", c.conn.RemoteAddr(), "bytes", len(data), "error", err)
+			continue
+		}
+
+		c.mu.Lock()
+		replyCh, ok := c.pending[reply.CorrelationID]
+		c.mu.Unlock()
+		if ok {
+			// The channel holds one reply, anything beyond that for the same ID is a duplicate and is dropped.
+			select {
+			case replyCh <- reply:
+			default:
+			}
+		}
+	}
+}
+
+// closer records why a client stopped working and wakes every call waiting on it.
+// Client, UDPClient and ReliableUDPSender all embed one.
+type closer struct {
+	once   sync.Once
+	closed chan struct{} // Closed once the client has stopped working
+	err    error         // Why it stopped, only read once closed is closed
+}
+
+func newCloser() *closer {
+	return &closer{closed: make(chan struct{})}
+}
+
+// fail records why the client stopped working and wakes every waiting call. Only the first error is kept.
+func (c *closer) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.closed)
+	})
+}
+
+// failed returns why the client stopped working, or nil if it is still working.
+func (c *closer) failed() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+package networktools
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+)
+
+// Names of the compressors that are always registered.
+const (
+	CompressionGzip  = "gzip"
+	CompressionFlate = "flate"
+)
+
+// DefaultCompressionThreshold is the smallest payload WithCompression compresses. Anything smaller rarely gets any smaller.
+const DefaultCompressionThreshold = 1024
+
+// ErrUnknownCompression is returned by DeserialiseRequest for a request compressed with a compressor that isn't registered.
+var ErrUnknownCompression = errors.New("request compressed with an unknown compressor")
+
+// Compressor compresses request payloads, see WithCompression.
+// Both ends have to have a compressor registered under the same name, the handshake's FeatureCompression is one way to check they do.
+type Compressor interface {
+	// Name is what the compressor is registered as and how requests say they were compressed with it.
+	Name() string
+	// Compress returns a writer that compresses whatever is written to it into w, flushing it all out on Close.
+	Compress(w io.Writer) (io.WriteCloser, error)
+	// Decompress returns a reader of the decompressed contents of r.
+	Decompress(r io.Reader) (io.Reader, error)
+}
+
+var compressors = struct {
+	sync.RWMutex
+	byName map[string]Compressor
+}{byName: map[string]Compressor{
+	CompressionGzip:  gzipCompressor{},
+	CompressionFlate: flateCompressor{},
+}}
+
+// RegisterCompressor makes a compressor available to WithCompression and DeserialiseRequest, replacing any registered under the same name.
+// Register compressors before sending or receiving requests, for example in an init function.
+//
+// Example:
+//
+//	func init() {
+//		networktools.RegisterCompressor(zstdCompressor{})
+//	}
+//
+//	req, err := networktools.GenerateRequest(snapshot, RequestSnapshot, networktools.WithCompression("zstd"))
+func RegisterCompressor(c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.byName[c.Name()] = c
+}
+
+func getCompressor(name string) (Compressor, bool) {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	c, ok := compressors.byName[name]
+	return c, ok
+}
+
+// WithCompression compresses the request's payload with the compressor registered under the name, if the payload is at least DefaultCompressionThreshold bytes.
+// The request says which compressor it used and DeserialiseRequest undoes it, so the receiver sees the original payload.
+// A payload that doesn't get any smaller is sent uncompressed.
+//
+// Example:
+//
+//	req, err := networktools.GenerateRequest(snapshot, RequestSnapshot, networktools.WithCompression(networktools.CompressionGzip))
+func WithCompression(name string) RequestOption {
+	return func(opts *requestOptions) {
+		opts.compression = name
+		if opts.compressionThreshold == 0 {
+			opts.compressionThreshold = DefaultCompressionThreshold
+		}
+	}
+}
+
+// WithCompressionThreshold changes the smallest payload WithCompression compresses.
+func WithCompressionThreshold(bytes int) RequestOption {
+	return func(opts *requestOptions) {
+		opts.compressionThreshold = bytes
+	}
+}
+
+// compress compresses the request's payload as the options ask.
+func (o *requestOptions) compress(req *pb.Request) error {
+	if o.compression == "" || len(req.Payload) < o.compressionThreshold {
+		return nil
+	}
+	c, ok := getCompressor(o.compression)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, o.compression)
+	}
+
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return fmt.Errorf("error compressing payload: %w", err)
+	}
+	if _, err := w.Write(req.Payload); err != nil {
+		return fmt.Errorf("error compressing payload: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error compressing payload: %w", err)
+	}
+
+	if buf.Len() < len(req.Payload) {
+		req.Payload = buf.Bytes()
+		req.Compression = o.compression
+	}
+	return nil
+}
+
+// decompress undoes the compression of a received request's payload.
+// The payload can't decompress to more than the size the request gives for it, nor more than maxSize, so a small request can't be made to take up lots of memory.
+// A maxSize of 0 leaves only the size the request gives.
+func decompress(req *pb.Request, maxSize uint32) error {
+	c, ok := getCompressor(req.Compression)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownCompression, req.Compression)
+	}
+	if overLimit(req.PayloadSize, maxSize) {
+		return fmt.Errorf("decompressed payload of %d bytes would exceed the limit of %d bytes", req.PayloadSize, maxSize)
+	}
+
+	r, err := c.Decompress(bytes.NewReader(req.Payload))
+	if err != nil {
+		return fmt.Errorf("error decompressing payload: %w", err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, int64(req.PayloadSize)+1)); err != nil {
+		return fmt.Errorf("error decompressing payload: %w", err)
+	}
+	if uint64(buf.Len()) != req.PayloadSize {
+		return fmt.Errorf("payload decompressed to %d bytes instead of %d", buf.Len(), req.PayloadSize)
+	}
+
+	req.Payload = buf.Bytes()
+	req.Compression = ""
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Name() string { return CompressionGzip }
+
+func (gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+type flateCompressor struct{}
+
+func (flateCompressor) Name() string { return CompressionFlate }
+
+func (flateCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (flateCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return flate.NewReader(r), nil
+}
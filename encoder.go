@@ -1,10 +1,23 @@
 package networktools
 
 import (
+	"fmt"
+
 	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// Field numbers from standards/request.proto, for the fields that are set on already serialised requests.
+const (
+	typeField          protowire.Number = 1
+	correlationIDField protowire.Number = 4
+	sequenceField      protowire.Number = 5
+	streamField        protowire.Number = 6
+	headersField       protowire.Number = 11
+	baseField          protowire.Number = 14
+)
+
 // GenerateRequest an object or slice of objects, with their request type and serialises them into a byte format that is able to be transmitted over a network.
 //
 // Example:
@@ -14,33 +27,59 @@ import (
 //	_ := deserialiseData(&ic, req.Request.Payload)
 //	newCamera := (Logic to generate camera object)
 //	outgoingReq, err := generateRequest(newCamera, RequestSuccessful)
-func GenerateRequest(data proto.Message, reqType uint8) ([]byte, error) {
-	var serializedRequest []byte
-	var err error
-	if data != nil {
-		// Serialize the proto.Message
-		serializedData, err := proto.Marshal(data)
-		if err != nil {
-			return nil, err
-		}
-		req := &pb.Request{
-			Type:        uint32(reqType),
-			PayloadSize: uint64(len(serializedData)),
-			Payload:     serializedData,
-		}
-		serializedRequest, err = proto.Marshal(req)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		serializedRequest, err = NewNullRequest(uint32(reqType))
-		if err != nil {
-			return nil, err
-		}
+//
+// Options such as WithHeader and WithCompression add to the request.
+func GenerateRequest(data proto.Message, reqType uint32, opts ...RequestOption) ([]byte, error) {
+	if IsReservedType(reqType) {
+		return nil, fmt.Errorf("%w: %d", ErrReservedType, reqType)
+	}
+	return generateRequest(data, reqType, opts...)
+}
 
+// generateRequest is GenerateRequest without the check for reserved types, for the library's own control messages.
+func generateRequest(data proto.Message, reqType uint32, opts ...RequestOption) ([]byte, error) {
+	if data == nil {
+		return generateRawRequest(nil, reqType, opts...)
 	}
 
-	return serializedRequest, nil
+	// Serialize the proto.Message
+	serializedData, err := proto.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return generateRawRequest(serializedData, reqType, opts...)
+}
+
+// GenerateRawRequest wraps an already serialised payload in the request standard without marshalling it again.
+// It is useful when the payload isn't a proto.Message, such as plain text or bytes received from elsewhere.
+//
+// Example:
+//
+//	outgoingReq, err := GenerateRawRequest([]byte("camera offline"), RequestFailed)
+func GenerateRawRequest(payload []byte, reqType uint32, opts ...RequestOption) ([]byte, error) {
+	if IsReservedType(reqType) {
+		return nil, fmt.Errorf("%w: %d", ErrReservedType, reqType)
+	}
+	return generateRawRequest(payload, reqType, opts...)
+}
+
+func generateRawRequest(payload []byte, reqType uint32, opts ...RequestOption) ([]byte, error) {
+	var options requestOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	req := &pb.Request{
+		Type:        reqType,
+		PayloadSize: uint64(len(payload)),
+		Payload:     payload,
+		Headers:     options.headers,
+	}
+	if err := options.compress(req); err != nil {
+		return nil, err
+	}
+	addChecksum(req)
+	return proto.Marshal(req)
 }
 
 func DeserialiseData(msg proto.Message, raw_data []byte) error {
@@ -49,6 +88,7 @@ func DeserialiseData(msg proto.Message, raw_data []byte) error {
 
 // DeserialiseRequest handles the deserialisation of raw data read from a socket into the request standard.
 // You will have to pair this with the DeserialiseData function as the meaning of each request type is left to the programmer.
+// A compressed payload is decompressed (see WithCompression) up to DefaultMaxMessageSize, and a payload that doesn't match its checksum gives an error wrapping ErrChecksumMismatch.
 //
 // Example:
 //
@@ -60,19 +100,102 @@ func DeserialiseData(msg proto.Message, raw_data []byte) error {
 //	cameraMap.removeCamera(c)
 
 func DeserialiseRequest(data []byte) (Request_Type, error) {
+	return deserialiseRequest(data, DefaultMaxMessageSize)
+}
+
+// deserialiseRequest is DeserialiseRequest with a limit on how large the payload may decompress to, 0 for no limit, so listeners can apply WithMaxMessageSize to it.
+func deserialiseRequest(data []byte, maxSize uint32) (Request_Type, error) {
 	request := &pb.Request{}
 	if err := proto.Unmarshal(data, request); err != nil {
 		return Request_Type{}, err
 	}
+	if err := verifyChecksum(request); err != nil {
+		return Request_Type{}, err
+	}
+	if request.Compression != "" {
+		if err := decompress(request, maxSize); err != nil {
+			return Request_Type{}, err
+		}
+	}
 
 	// Convert the protobuf Request to your custom Request_Type
 	return Request_Type{
-		Type:          uint8(request.Type), // Note: Converting uint32 to uint8
+		Type:          request.Type,
 		PayloadLength: request.PayloadSize,
 		Payload:       request.Payload,
+		CorrelationID: request.CorrelationId,
+		Sequence:      request.Sequence,
+		Headers:       request.Headers,
+		stream:        request.Stream,
+		ack:           request.Ack,
+		base:          request.Base,
+		messageID:     request.MessageId,
+		fragmentIndex: request.FragmentIndex,
+		fragmentCount: request.FragmentCount,
 	}, nil
 }
 
+// CorrelateReply tags a serialised reply with the correlation ID of the request it answers, so a Client waiting on that request receives it.
+// The Router does this for you. You only need it when replying by hand, for example from the channel returned by Create_TCP_Listener.
+// Requests that did not come from a Client have an ID of 0, in which case the reply is returned unchanged.
+//
+// Example:
+//
+//	data := <-request_channel
+//	reply, _ := networktools.GenerateRequest(camera, RequestSuccessful)
+//	err := networktools.SendTCPReply(data.Conn, networktools.CorrelateReply(reply, data.Request))
+func CorrelateReply(reply []byte, req Request_Type) []byte {
+	return setCorrelationID(reply, req.CorrelationID)
+}
+
+// setCorrelationID sets the correlation ID on a serialised request without unmarshalling it.
+// Protobuf keeps the last occurrence of a scalar field, so appending the field overrides any ID already present.
+func setCorrelationID(data []byte, id uint64) []byte {
+	if id == 0 {
+		return data
+	}
+	return appendVarintField(data, correlationIDField, id)
+}
+
+// setSequence numbers a serialised request for reliable delivery, in the same way setCorrelationID tags it.
+// base is the lowest sequence number the sender is still waiting to have acknowledged.
+func setSequence(data []byte, stream uint64, sequence uint64, base uint64) []byte {
+	return appendVarintField(appendVarintField(appendVarintField(data, streamField, stream), sequenceField, sequence), baseField, base)
+}
+
+// requestType reads the type of a serialised request without deserialising the rest of it, so the payload isn't checked or decompressed.
+// It returns false if the request can't be parsed.
+func requestType(data []byte) (uint32, bool) {
+	var reqType uint64
+	for len(data) > 0 {
+		field, wireType, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return 0, false
+		}
+		data = data[n:]
+
+		if field == typeField && wireType == protowire.VarintType {
+			// Protobuf keeps the last occurrence of a scalar field, so carry on looking.
+			reqType, n = protowire.ConsumeVarint(data)
+		} else {
+			n = protowire.ConsumeFieldValue(field, wireType, data)
+		}
+		if n < 0 {
+			return 0, false
+		}
+		data = data[n:]
+	}
+	return uint32(reqType), true
+}
+
+// appendVarintField returns a copy of data with the field appended, leaving data itself untouched since the caller may still be using it.
+func appendVarintField(data []byte, field protowire.Number, value uint64) []byte {
+	tagged := make([]byte, len(data), len(data)+protowire.SizeTag(field)+protowire.SizeVarint(value))
+	copy(tagged, data)
+	tagged = protowire.AppendTag(tagged, field, protowire.VarintType)
+	return protowire.AppendVarint(tagged, value)
+}
+
 func NewNullRequest(requestType uint32) ([]byte, error) {
 	req := &pb.Request{
 		Type: requestType,
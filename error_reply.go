@@ -0,0 +1,116 @@
+package networktools
+
+import (
+	"errors"
+	"fmt"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Codes of the errors the library replies with itself. Applications pick their own codes from 100 upwards.
+const (
+	CodeHandlerFailed uint32 = iota + 1 // The handler returned an error that didn't say which code to use
+	CodeDecodeFailed                    // A typed handler could not decode the payload it was sent
+	CodeNoHandler                       // No handler is registered for the request type, only sent over TCP
+)
+
+// RemoteError is an error reported by the peer in a RequestError reply, in place of the reply that was expected.
+// Handle_Single_TCP_Exchange and the other exchange functions, Client and UDPClient all return one when the reply is an error.
+//
+// A handler registered with a Router can return a RemoteError, or an error wrapping one, to choose the code and details the sender receives.
+// Any other error is sent with CodeHandlerFailed and the error's message.
+//
+// Example:
+//
+//	router.Handle(RequestMoveCamera, func(ctx context.Context, req networktools.Request_Type, addr net.Addr) ([]byte, error) {
+//		if !cameraMap.exists(id) {
+//			details, _ := anypb.New(&CameraProto{Id: id})
+//			return nil, &networktools.RemoteError{Code: CodeUnknownCamera, Message: "no such camera", Details: details}
+//		}
+//		(code code code)
+//	})
+//
+//	_, err := client.Call(ctx, req)
+//	var remoteErr *networktools.RemoteError
+//	if errors.As(err, &remoteErr) && remoteErr.Code == CodeUnknownCamera {
+//		(code code code)
+//	}
+type RemoteError struct {
+	Code    uint32
+	Message string
+	Details *anypb.Any // Anything else the peer wanted to say, nil if there is nothing
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("remote error %d: %s", e.Code, e.Message)
+}
+
+// GenerateErrorReply serialises an error into a RequestError reply, the way the Router does when a handler fails.
+// You only need it when replying by hand, for example from the channel returned by Create_TCP_Listener.
+//
+// Example:
+//
+//	data := <-request_channel
+//	reply, _ := networktools.GenerateErrorReply(fmt.Errorf("camera %d is offline", id))
+//	err := networktools.SendTCPReply(data.Conn, networktools.CorrelateReply(reply, data.Request))
+func GenerateErrorReply(err error) ([]byte, error) {
+	var remoteErr *RemoteError
+	if !errors.As(err, &remoteErr) {
+		remoteErr = &RemoteError{Code: CodeHandlerFailed, Message: err.Error()}
+	}
+
+	payload, marshalErr := proto.Marshal(&pb.Error{
+		Code:    remoteErr.Code,
+		Message: remoteErr.Message,
+		Details: remoteErr.Details,
+	})
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return generateRawRequest(payload, RequestError)
+}
+
+// ReplyError returns the *RemoteError carried by a RequestError reply, or nil if the reply isn't an error.
+//
+// Example:
+//
+//	data, _ := networktools.Handle_Single_TCP_Exchange(target, req, 1024)
+//	reply, _ := networktools.DeserialiseRequest(data)
+//	if err := networktools.ReplyError(reply); err != nil {
+//		return err
+//	}
+func ReplyError(reply Request_Type) error {
+	if reply.Type != RequestError {
+		return nil
+	}
+	var msg pb.Error
+	if err := proto.Unmarshal(reply.Payload, &msg); err != nil {
+		return fmt.Errorf("error deserialising error reply: %w", err)
+	}
+	return &RemoteError{Code: msg.Code, Message: msg.Message, Details: msg.Details}
+}
+
+// checkReply returns the error carried by a serialised reply, for the exchange functions that hand back raw replies.
+// A reply that can't be deserialised is left for the caller to find out about.
+func checkReply(data []byte) error {
+	reply, err := DeserialiseRequest(data)
+	if err != nil {
+		return nil
+	}
+	return ReplyError(reply)
+}
+
+// errorReply is what the Router sends back when handling a request failed.
+// Control messages are never answered with an error, so two routers can't keep sending each other error replies.
+func errorReply(req Request_Type, err error) []byte {
+	if IsReservedType(req.Type) {
+		return nil
+	}
+	reply, err := GenerateErrorReply(err)
+	if err != nil {
+		return nil
+	}
+	return reply
+}
@@ -0,0 +1,41 @@
+package networktools
+
+import (
+	"fmt"
+	"net"
+)
+
+// ListenerError describes something that went wrong while a listener was running, such as a failed accept or a request that could not be deserialised.
+// These errors don't stop the listener, they are passed to the function given to WithErrorHandler so you can see what was dropped.
+type ListenerError struct {
+	Op     string   // What the listener was doing: "accept", "handshake", "read", "unseal", "deserialise", "handle" or "reply"
+	Remote net.Addr // The peer involved, nil when there isn't one
+	Err    error
+}
+
+func (e *ListenerError) Error() string {
+	if e.Remote == nil {
+		return fmt.Sprintf("%s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Remote, e.Err)
+}
+
+func (e *ListenerError) Unwrap() error {
+	return e.Err
+}
+
+// ShutdownError is returned by Shutdown when the context ended before the listener finished on its own.
+// Whatever was still running has been cut off: the connections listed were closed and the handlers counted were cancelled through their context.
+type ShutdownError struct {
+	Closed   []net.Addr // Connections that were still open and have been closed
+	Handlers int        // Handlers that were still running
+	Err      error      // Why the shutdown was cut short, the context's error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown incomplete, closed %d connections with %d handlers still running: %v", len(e.Closed), e.Handlers, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
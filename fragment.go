@@ -0,0 +1,264 @@
+package networktools
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultFragmentSize is the largest datagram sent without splitting it up.
+// It leaves room for IP and UDP headers, sealing (see SealKeys) and the odd tunnel inside a 1500 byte Ethernet MTU, so datagrams aren't fragmented by the network instead.
+const DefaultFragmentSize = 1400
+
+// fragmentOverhead is the most the fields of a fragment can add to the piece of the request it carries.
+// That is a tag and varint each for the message ID, index and count, plus the payload's tag and length.
+const fragmentOverhead = (1 + 10) + (1 + 5) + (1 + 5) + (1 + 3)
+
+// minFragmentPayload is the least of the request every fragment but the last carries.
+// FragmentRequest never sends less, which lets a listener tell how many fragments a request of the largest size it accepts could need.
+const minFragmentPayload = 256
+
+const (
+	// fragmentCost and partialCost are roughly what holding a fragment, and a request waiting on fragments, takes up on top of the payload.
+	// They count towards the reassembly memory limit, so a flood of empty fragments can't get round it.
+	fragmentCost = 64
+	partialCost  = 256
+
+	// maxPartialsPerPeer is how many requests one sender can have waiting on fragments at once. Past that its oldest is dropped.
+	maxPartialsPerPeer = 64
+)
+
+// nextMessageID numbers fragmented requests. It starts somewhere random so a restarted sender doesn't reuse the IDs of the one before it.
+var nextMessageID = func() uint64 {
+	var start [8]byte
+	rand.Read(start[:])
+	return binary.BigEndian.Uint64(start[:])
+}()
+
+// FragmentRequest splits a request generated with GenerateRequest into datagrams of at most maxDatagramSize bytes.
+// A request that already fits is returned as it is, on its own. The UDP listeners put the fragments back together before handing the request on.
+// The datagrams have to be big enough for each fragment to carry at least 256 bytes of the request, listeners drop fragments carrying less.
+// SendUDP, UDPSender, UDPClient, ReliableUDPSender and UDPNetworkData.Reply all do this for you with DefaultFragmentSize, so you only need it to send over your own socket or pick a different size.
+//
+// Example:
+//
+//	req, _ := networktools.GenerateRequest(snapshot, RequestSnapshot)
+//	fragments, err := networktools.FragmentRequest(req, 1200)
+//	if err != nil {
+//		return err
+//	}
+//	for _, fragment := range fragments {
+//		conn.WriteTo(fragment, addr)
+//	}
+func FragmentRequest(data []byte, maxDatagramSize int) ([][]byte, error) {
+	if len(data) <= maxDatagramSize {
+		return [][]byte{data}, nil
+	}
+	if maxDatagramSize > maxUDPDatagramSize {
+		maxDatagramSize = maxUDPDatagramSize
+	}
+	if maxDatagramSize < fragmentOverhead+minFragmentPayload {
+		return nil, fmt.Errorf("datagram size of %d bytes leaves no room for a fragment", maxDatagramSize)
+	}
+
+	chunkSize := maxDatagramSize - fragmentOverhead
+	count := (len(data) + chunkSize - 1) / chunkSize
+	messageID := atomic.AddUint64(&nextMessageID, 1)
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		fragment, err := proto.Marshal(&pb.Request{
+			MessageId:     messageID,
+			FragmentIndex: uint32(i),
+			FragmentCount: uint32(count),
+			Payload:       data[i*chunkSize : end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, fragment)
+	}
+	return fragments, nil
+}
+
+// writeFragmented sends a request with the write function, split into fragments of DefaultFragmentSize if it is too big for one datagram.
+func writeFragmented(data []byte, write func([]byte) error) error {
+	fragments, err := FragmentRequest(data, DefaultFragmentSize)
+	if err != nil {
+		return err
+	}
+	for _, fragment := range fragments {
+		if err := write(fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fragmentKey identifies a fragmented request, by who sent it and its message ID.
+type fragmentKey struct {
+	addr      netip.AddrPort
+	messageID uint64
+}
+
+// partialMessage holds the fragments of a request that have arrived so far.
+type partialMessage struct {
+	fragments map[uint32][]byte
+	count     uint32
+	size      int // Bytes of the request that have arrived
+	held      int // What the request counts for against the memory limit, its size plus fragmentCost and partialCost
+	started   time.Time
+}
+
+// reassembler puts fragmented requests back together, within limits on how long it waits for the rest of a request and how much it holds while waiting.
+// It is only used from a single read loop, so it needs no locking.
+type reassembler struct {
+	timeout    time.Duration // How long to wait for the rest of a request, 0 for no limit
+	maxMemory  int           // Most bytes held across every incomplete request, 0 for no limit
+	maxMessage uint32        // Largest request accepted once put back together, 0 for no limit
+
+	held     int
+	partials map[fragmentKey]*partialMessage
+	perPeer  map[netip.AddrPort]int // How many requests each sender has waiting on fragments
+}
+
+func newReassembler(timeout time.Duration, maxMemory int, maxMessage uint32) *reassembler {
+	return &reassembler{
+		timeout:    timeout,
+		maxMemory:  maxMemory,
+		maxMessage: maxMessage,
+		partials:   make(map[fragmentKey]*partialMessage),
+		perPeer:    make(map[netip.AddrPort]int),
+	}
+}
+
+// add stores a fragment and returns the serialised request once every fragment of it has arrived, nil until then.
+// Repeated fragments are ignored, so a request retransmitted as a whole is still put together once.
+func (r *reassembler) add(from net.Addr, req Request_Type, now time.Time) ([]byte, error) {
+	if req.fragmentIndex >= req.fragmentCount {
+		return nil, fmt.Errorf("fragment %d of a request with %d fragments", req.fragmentIndex, req.fragmentCount)
+	}
+	if overLimit(uint64(req.fragmentCount-1)*minFragmentPayload, r.maxMessage) {
+		return nil, fmt.Errorf("request of %d fragments exceeds the limit of %d bytes", req.fragmentCount, r.maxMessage)
+	}
+	if req.fragmentIndex != req.fragmentCount-1 && len(req.Payload) < minFragmentPayload {
+		return nil, fmt.Errorf("fragment %d of %d carries only %d bytes", req.fragmentIndex, req.fragmentCount, len(req.Payload))
+	}
+
+	key := fragmentKey{addr: addrKey(from), messageID: req.messageID}
+	partial := r.partials[key]
+	if partial == nil {
+		if r.perPeer[key.addr] >= maxPartialsPerPeer {
+			oldest, _ := r.oldest(func(k fragmentKey) bool { return k.addr == key.addr })
+			r.discard(oldest)
+		}
+		if err := r.makeRoom(key, partialCost); err != nil {
+			return nil, err
+		}
+		partial = &partialMessage{fragments: make(map[uint32][]byte), count: req.fragmentCount, held: partialCost, started: now}
+		r.partials[key] = partial
+		r.perPeer[key.addr]++
+		r.held += partialCost
+	}
+	if req.fragmentCount != partial.count {
+		r.discard(key)
+		return nil, fmt.Errorf("fragments of message %d disagree on the fragment count", req.messageID)
+	}
+	if _, ok := partial.fragments[req.fragmentIndex]; ok {
+		return nil, nil
+	}
+
+	if overLimit(uint64(partial.size)+uint64(len(req.Payload)), r.maxMessage) {
+		r.discard(key)
+		return nil, fmt.Errorf("fragmented request exceeds the limit of %d bytes", r.maxMessage)
+	}
+	cost := len(req.Payload) + fragmentCost
+	if err := r.makeRoom(key, cost); err != nil {
+		r.discard(key)
+		return nil, err
+	}
+
+	partial.fragments[req.fragmentIndex] = req.Payload
+	partial.size += len(req.Payload)
+	partial.held += cost
+	r.held += cost
+
+	if uint32(len(partial.fragments)) < partial.count {
+		return nil, nil
+	}
+
+	whole := make([]byte, 0, partial.size)
+	for i := uint32(0); i < partial.count; i++ {
+		whole = append(whole, partial.fragments[i]...)
+	}
+	r.discard(key)
+	return whole, nil
+}
+
+// makeRoom drops the oldest incomplete requests, other than the one being added to, until there is room to hold size more bytes.
+func (r *reassembler) makeRoom(adding fragmentKey, size int) error {
+	if r.maxMemory == 0 {
+		return nil
+	}
+	if size > r.maxMemory {
+		return fmt.Errorf("fragment of %d bytes exceeds the reassembly memory limit of %d bytes", size, r.maxMemory)
+	}
+
+	for r.held+size > r.maxMemory {
+		oldest, ok := r.oldest(func(key fragmentKey) bool { return key != adding })
+		if !ok {
+			return fmt.Errorf("fragmented request exceeds the reassembly memory limit of %d bytes", r.maxMemory)
+		}
+		r.discard(oldest)
+	}
+	return nil
+}
+
+// oldest returns the incomplete request that has been waiting longest out of those matching, false if none match.
+func (r *reassembler) oldest(matches func(fragmentKey) bool) (fragmentKey, bool) {
+	var oldest fragmentKey
+	var oldestStart time.Time
+	for key, partial := range r.partials {
+		if matches(key) && (oldestStart.IsZero() || partial.started.Before(oldestStart)) {
+			oldest, oldestStart = key, partial.started
+		}
+	}
+	return oldest, !oldestStart.IsZero()
+}
+
+// sweep drops the requests that have been waiting for their remaining fragments longer than the timeout and returns how many it dropped.
+func (r *reassembler) sweep(now time.Time) int {
+	if r.timeout == 0 {
+		return 0
+	}
+
+	dropped := 0
+	for key, partial := range r.partials {
+		if now.Sub(partial.started) > r.timeout {
+			r.discard(key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (r *reassembler) discard(key fragmentKey) {
+	if partial, ok := r.partials[key]; ok {
+		r.held -= partial.held
+		delete(r.partials, key)
+		if r.perPeer[key.addr]--; r.perPeer[key.addr] == 0 {
+			delete(r.perPeer, key.addr)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package networktools
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize is the largest TCP message a listener will accept unless told otherwise.
+const DefaultMaxMessageSize = 64 << 20
+
+// frameHeaderSize is the size of the big-endian length prefix written before every TCP message.
+const frameHeaderSize = 4
+
+// WriteFrame writes data to the writer prefixed with its length so the receiver can recover the exact message boundaries.
+// TCP is a stream, so without the prefix two messages may arrive as one read or one message may arrive across several.
+// The header and the data are written in a single call so that concurrent writers on the same connection do not interleave.
+//
+// Example:
+//
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	err := networktools.WriteFrame(conn, req)
+//	if err != nil {
+//		return err
+//	}
+func WriteFrame(w io.Writer, data []byte) error {
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		return fmt.Errorf("frame of %d bytes exceeds the maximum frame size", len(data))
+	}
+
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a single length-prefixed message written by WriteFrame and returns it whole.
+// It blocks until the entire message has arrived, regardless of how TCP split it into segments.
+//
+// Example:
+//
+//	data, err := networktools.ReadFrame(conn)
+//	if err != nil {
+//		return err
+//	}
+//	req, err := networktools.DeserialiseRequest(data)
+func ReadFrame(r io.Reader) ([]byte, error) {
+	return readFrame(r, 0)
+}
+
+// readFrame works like ReadFrame but rejects frames larger than maxSize, with 0 accepting any size.
+func readFrame(r io.Reader, maxSize uint32) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	return readFrameBody(r, header, maxSize)
+}
+
+// readFrameBody reads the message announced by header, rejecting it if it is larger than maxSize.
+// A maxSize of 0 accepts any size.
+func readFrameBody(r io.Reader, header [frameHeaderSize]byte, maxSize uint32) ([]byte, error) {
+	size := binary.BigEndian.Uint32(header[:])
+	if overLimit(uint64(size), maxSize) {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d bytes", size, maxSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// overLimit reports whether size is larger than limit, with a limit of 0 meaning there is no limit.
+func overLimit(size uint64, limit uint32) bool {
+	return limit != 0 && size > uint64(limit)
+}
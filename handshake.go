@@ -0,0 +1,236 @@
+package networktools
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtocolVersion is the version of the request standard this library speaks, see standards/request.proto.
+// It goes up whenever a change means older versions would misread requests, and both ends of a handshake have to agree on it.
+const ProtocolVersion uint32 = 1
+
+// Feature names for use in a Hello, so both ends spell them the same way.
+// The library doesn't act on them, they say what the application on each end is prepared to use.
+const (
+	FeatureCompression = "compression"
+	FeatureEncryption  = "encryption"
+	FeatureFraming     = "framing"
+)
+
+// Hello is what each end of a TCP connection announces about itself in a handshake, see WithHandshake and ClientHandshake.
+// The handshake fails if the two ends speak different protocol versions, belong to different applications,
+// or one requires a feature the other doesn't support.
+type Hello struct {
+	Version          uint32   // The protocol version, ProtocolVersion when left at 0
+	AppID            string   // Which application is on this end, left empty to accept any application
+	Features         []string // Features this end supports
+	RequiredFeatures []string // Features the other end has to support for the connection to go ahead
+}
+
+// HandshakeError is returned when a handshake fails, saying why.
+type HandshakeError struct {
+	Reason string
+	Remote bool // Whether the other end refused the connection, rather than this one
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Remote {
+		return "handshake refused by remote: " + e.Reason
+	}
+	return "handshake failed: " + e.Reason
+}
+
+// negotiate checks the other end's Hello against ours and returns what the two agree on: the other end's Hello with its Features narrowed to the ones both support.
+// remoteName is what the other end is called in the reasons given for a failure, "client" or "server".
+func negotiate(local Hello, remote Hello, remoteName string) (Hello, error) {
+	local = local.withDefaults()
+
+	if remote.Version != local.Version {
+		return Hello{}, &HandshakeError{Reason: fmt.Sprintf("the %s speaks protocol version %d instead of %d", remoteName, remote.Version, local.Version)}
+	}
+	if local.AppID != "" && remote.AppID != "" && remote.AppID != local.AppID {
+		return Hello{}, &HandshakeError{Reason: fmt.Sprintf("the %s belongs to application %q instead of %q", remoteName, remote.AppID, local.AppID)}
+	}
+	if missing := missingFeatures(local.RequiredFeatures, remote.Features); len(missing) > 0 {
+		return Hello{}, &HandshakeError{Reason: fmt.Sprintf("the %s does not support required features %v", remoteName, missing)}
+	}
+	if missing := missingFeatures(remote.RequiredFeatures, local.Features); len(missing) > 0 {
+		return Hello{}, &HandshakeError{Reason: fmt.Sprintf("the %s requires unsupported features %v", remoteName, missing)}
+	}
+
+	agreed := remote
+	agreed.Features = nil
+	for _, feature := range remote.Features {
+		if hasFeature(local.Features, feature) {
+			agreed.Features = append(agreed.Features, feature)
+		}
+	}
+	return agreed, nil
+}
+
+func (h Hello) withDefaults() Hello {
+	if h.Version == 0 {
+		h.Version = ProtocolVersion
+	}
+	return h
+}
+
+func missingFeatures(required []string, supported []string) []string {
+	var missing []string
+	for _, feature := range required {
+		if !hasFeature(supported, feature) {
+			missing = append(missing, feature)
+		}
+	}
+	return missing
+}
+
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (h Hello) toProto() *pb.Hello {
+	return &pb.Hello{
+		ProtocolVersion:  h.Version,
+		AppId:            h.AppID,
+		Features:         h.Features,
+		RequiredFeatures: h.RequiredFeatures,
+	}
+}
+
+func helloFromProto(hello *pb.Hello) Hello {
+	return Hello{
+		Version:          hello.ProtocolVersion,
+		AppID:            hello.AppId,
+		Features:         hello.Features,
+		RequiredFeatures: hello.RequiredFeatures,
+	}
+}
+
+// ClientHandshake performs the client's side of a handshake on a connection to a listener created with WithHandshake.
+// It has to be the first thing sent on the connection. It returns the server's Hello with Features narrowed to the ones both ends support,
+// or a *HandshakeError if the two ends are incompatible, in which case the connection should be closed.
+//
+// Example:
+//
+//	conn, err := net.Dial("tcp", "192.168.1.76:5057")
+//	if err != nil {
+//		return err
+//	}
+//	defer conn.Close()
+//	server, err := networktools.ClientHandshake(ctx, conn, networktools.Hello{AppID: "camera-control"})
+//	if err != nil {
+//		return err
+//	}
+//	err = networktools.SendTCPReply(conn, req)
+func ClientHandshake(ctx context.Context, conn net.Conn, hello Hello) (Hello, error) {
+	hello = hello.withDefaults()
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	data, err := proto.Marshal(hello.toProto())
+	if err != nil {
+		return Hello{}, err
+	}
+	if err := WriteFrame(conn, data); err != nil {
+		return Hello{}, fmt.Errorf("error sending hello: %w", contextError(ctx, err))
+	}
+
+	data, err = readFrame(conn, DefaultMaxMessageSize)
+	if err != nil {
+		return Hello{}, fmt.Errorf("error reading hello: %w", contextError(ctx, err))
+	}
+	var reply pb.Hello
+	if err := proto.Unmarshal(data, &reply); err != nil {
+		return Hello{}, fmt.Errorf("error deserialising hello: %w", err)
+	}
+	if reply.Error != "" {
+		return Hello{}, &HandshakeError{Reason: reply.Error, Remote: true}
+	}
+
+	return negotiate(hello, helloFromProto(&reply), "server")
+}
+
+// Dial_Client_With_Handshake works the same as Dial_Client but performs a handshake before returning the client.
+// It returns the server's Hello, with Features narrowed to the ones both ends support.
+//
+// Example:
+//
+//	client, server, err := networktools.Dial_Client_With_Handshake(ctx, "192.168.1.76:5057", networktools.Hello{
+//		AppID:    "camera-control",
+//		Features: []string{networktools.FeatureCompression},
+//	})
+func Dial_Client_With_Handshake(ctx context.Context, target_address string, hello Hello) (*Client, Hello, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", target_address)
+	if err != nil {
+		return nil, Hello{}, fmt.Errorf("error dialing TCP: %w", err)
+	}
+
+	server, err := ClientHandshake(ctx, conn, hello)
+	if err != nil {
+		conn.Close()
+		return nil, Hello{}, err
+	}
+	return NewClient(conn), server, nil
+}
+
+// acceptHello performs the server's side of a handshake on a freshly accepted connection.
+// The caller sets the read deadline the hello has to arrive by, see tracker.awaitRequest.
+// A client that is refused is told why before the connection is closed.
+func acceptHello(conn net.Conn, cfg ServerConfig) (Hello, error) {
+	data, err := readFrame(conn, cfg.MaxMessageSize)
+	if err != nil {
+		return Hello{}, fmt.Errorf("error reading hello: %w", err)
+	}
+
+	var received pb.Hello
+	if err := proto.Unmarshal(data, &received); err != nil || received.ProtocolVersion == 0 {
+		// Most likely a client that doesn't know about handshakes has sent a request straight away.
+		err = &HandshakeError{Reason: "expected a hello, the client may not support handshakes"}
+		refuse(conn, cfg, err)
+		return Hello{}, err
+	}
+
+	client, err := negotiate(*cfg.Handshake, helloFromProto(&received), "client")
+	if err != nil {
+		refuse(conn, cfg, err)
+		return Hello{}, err
+	}
+
+	reply, err := proto.Marshal(cfg.Handshake.withDefaults().toProto())
+	if err != nil {
+		return Hello{}, err
+	}
+	conn.SetWriteDeadline(deadline(cfg.WriteTimeout))
+	if err := WriteFrame(conn, reply); err != nil {
+		return Hello{}, fmt.Errorf("error sending hello: %w", err)
+	}
+	conn.SetWriteDeadline(deadline(0))
+	return client, nil
+}
+
+// refuse tells the client why its handshake failed.
+func refuse(conn net.Conn, cfg ServerConfig, reason error) {
+	hello := cfg.Handshake.withDefaults().toProto()
+	if handshakeErr, ok := reason.(*HandshakeError); ok {
+		hello.Error = handshakeErr.Reason
+	} else {
+		hello.Error = reason.Error()
+	}
+
+	if data, err := proto.Marshal(hello); err == nil {
+		conn.SetWriteDeadline(deadline(cfg.WriteTimeout))
+		WriteFrame(conn, data)
+	}
+}
@@ -0,0 +1,98 @@
+package networktools
+
+import (
+	"context"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RequestOption changes something about a request as GenerateRequest or GenerateRawRequest builds it.
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	headers              map[string]string
+	compression          string
+	compressionThreshold int
+}
+
+// WithHeader sets a header on the request, such as a trace ID or an auth token. The receiver finds it in Request_Type.Headers.
+//
+// Example:
+//
+//	req, err := networktools.GenerateRequest(camera, RequestCamera,
+//		networktools.WithHeader("trace-id", traceID),
+//		networktools.WithHeader("tenant", "warehouse-3"))
+func WithHeader(key string, value string) RequestOption {
+	return func(opts *requestOptions) {
+		if opts.headers == nil {
+			opts.headers = make(map[string]string)
+		}
+		opts.headers[key] = value
+	}
+}
+
+// WithHeaders sets every header in the map on the request, see WithHeader.
+func WithHeaders(headers map[string]string) RequestOption {
+	return func(opts *requestOptions) {
+		for key, value := range headers {
+			WithHeader(key, value)(opts)
+		}
+	}
+}
+
+// addHeaders adds headers to a serialised request without unmarshalling it, leaving any header the request already has as it is.
+// Protobuf merges map entries in the order they appear and keeps the last value for a key, so the new entries go in front of the existing ones.
+func addHeaders(data []byte, headers map[string]string) []byte {
+	if len(headers) == 0 {
+		return data
+	}
+
+	// Sorted so the same headers always serialise the same way.
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var entries []byte
+	for _, key := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, headers[key])
+
+		entries = protowire.AppendTag(entries, headersField, protowire.BytesType)
+		entries = protowire.AppendBytes(entries, entry)
+	}
+	return append(entries, data...)
+}
+
+type headersKey struct{}
+
+// ContextWithHeaders returns a context carrying the headers, on top of any the context already carries.
+// Requests sent through a Client or UDPClient with the context get the headers, unless they set the same header themselves.
+// Handlers called by a Router already have the incoming request's headers in their context, so calls they make pass them on.
+//
+// Example:
+//
+//	ctx = networktools.ContextWithHeaders(ctx, map[string]string{"trace-id": traceID})
+//	reply, err := client.Call(ctx, req)
+func ContextWithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	merged := make(map[string]string)
+	for key, value := range ContextHeaders(ctx) {
+		merged[key] = value
+	}
+	for key, value := range headers {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// ContextHeaders returns the headers the context carries, see ContextWithHeaders. The map must not be modified.
+// Inside a handler called by a Router these are the headers of the request being handled.
+func ContextHeaders(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey{}).(map[string]string)
+	return headers
+}
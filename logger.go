@@ -0,0 +1,51 @@
+package networktools
+
+import "sync/atomic"
+
+// Logger receives everything the package has to report, from listener startup to dropped requests.
+// Each message comes with key value pairs describing it, such as "remote" for the peer's address, "type" for the request type and "bytes" for a size.
+// A *slog.Logger already satisfies Logger, see NewSlogLogger.
+//
+// Example:
+//
+//	networktools.SetDefaultLogger(slog.Default())
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithLogger(myLogger))
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// NopLogger discards everything it is given. It is the default, so the package is silent until you give it a Logger.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...any) {}
+func (NopLogger) Info(msg string, args ...any)  {}
+func (NopLogger) Warn(msg string, args ...any)  {}
+func (NopLogger) Error(msg string, args ...any) {}
+
+// loggerHolder lets an interface value be stored in an atomic.Value, which needs every value stored to have the same concrete type.
+type loggerHolder struct {
+	logger Logger
+}
+
+var packageLogger atomic.Value
+
+func init() {
+	packageLogger.Store(loggerHolder{NopLogger{}})
+}
+
+// SetDefaultLogger sets the Logger used by the helper functions, Clients and any listener created without WithLogger.
+// Passing nil silences the package again.
+func SetDefaultLogger(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	packageLogger.Store(loggerHolder{logger})
+}
+
+// defaultLogger returns the Logger set with SetDefaultLogger.
+func defaultLogger() Logger {
+	return packageLogger.Load().(loggerHolder).logger
+}
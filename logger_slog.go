@@ -0,0 +1,16 @@
+//go:build go1.21
+
+package networktools
+
+import "log/slog"
+
+// NewSlogLogger adapts a *slog.Logger to the Logger interface.
+// A *slog.Logger can be used as a Logger directly, this exists to make that explicit.
+//
+// Example:
+//
+//	handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+//	networktools.SetDefaultLogger(networktools.NewSlogLogger(slog.New(handler)))
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return logger
+}
@@ -1,16 +1,20 @@
 package networktools
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
-	"net/http"
 	"strings"
 	"time"
 )
 
+// replyTimeout is how long Get_TCP_Reply waits for a reply when no context is given.
+const replyTimeout = 5 * time.Second
+
 // SendUDP takes an address and data and uses UDP to send transmit the data.
 // It is advised to use the Request format provided in standards.go and serialise it using GenerateRequest. These are included within the package to make your life easier.
+// Every call opens a new socket on a port picked by the system, so concurrent sends don't get in each other's way. Use a UDPSender to keep one socket open for many sends, or to send from a port of your choosing.
 //
 // Example:
 //
@@ -28,34 +32,61 @@ import (
 //
 //	fmt.Printf("Successfully transmitted")
 func SendUDP(target_address string, data []byte) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", target_address)
-	if err != nil {
-		return err
-	}
+	return SendUDPContext(context.Background(), target_address, data)
+}
 
-	localAddr, err := net.ResolveUDPAddr("udp", ":8000")
-	if err != nil {
-		return err
-	}
+// SendUDPContext works the same as SendUDP but gives up once the context is cancelled or its deadline passes.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+//	defer cancel()
+//	err := SendUDPContext(ctx, req.Addr.String(), outgoingReq)
+func SendUDPContext(ctx context.Context, target_address string, data []byte) error {
+	return sendUDP(ctx, target_address, data, nil)
+}
+
+// SendSealedUDP works the same as SendUDP but seals every datagram with the keys, for a listener created with WithSealKeys.
+//
+// Example:
+//
+//	keys, _ := networktools.NewSealKeys(1, map[uint32][]byte{1: key})
+//	err := networktools.SendSealedUDP(req.Addr.String(), outgoingReq, keys)
+func SendSealedUDP(target_address string, data []byte, keys *SealKeys) error {
+	return SendSealedUDPContext(context.Background(), target_address, data, keys)
+}
 
-	conn, err := net.DialUDP("udp", localAddr, udpAddr)
+// SendSealedUDPContext works the same as SendSealedUDP but gives up once the context is cancelled or its deadline passes.
+func SendSealedUDPContext(ctx context.Context, target_address string, data []byte, keys *SealKeys) error {
+	return sendUDP(ctx, target_address, data, keys)
+}
+
+func sendUDP(ctx context.Context, target_address string, data []byte, keys *SealKeys) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target_address)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	// send the transmission
+	stop := watchWrites(ctx, conn)
+	defer stop()
 
-	_, err = conn.Write(data)
+	// send the transmission, in fragments if it won't fit in one datagram
+	err = writeFragmented(data, sealedWrite(keys, sealRequest, func(datagram []byte) error {
+		_, err := conn.Write(datagram)
+		return err
+	}))
 
 	if err != nil {
-		return err
+		return contextError(ctx, err)
 	}
 	return nil
 }
 
 // SendInitialTCP is used to start a connection between two machines using TCP.
 // It works similarly to SendUDP with the distinction being this function returns a connection.
+// The data is sent as a single length-prefixed frame (see WriteFrame) so the receiver reads it back whole.
 // Be aware you will have to close the connection yourself. It was chosen to defer this to the programmer so more complex exchanges could be handled.
 //
 // Example:
@@ -66,27 +97,44 @@ func SendUDP(target_address string, data []byte) error {
 //	}
 //	defer conn.Close() // Ensure the connection is closed when we're done
 func SendInitialTCP(target_address string, data []byte) (net.Conn, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", target_address)
-	if err != nil {
-		return nil, fmt.Errorf("error resolving address: %w", err)
-	}
+	return SendInitialTCPContext(context.Background(), target_address, data)
+}
 
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+// SendInitialTCPContext works the same as SendInitialTCP but the context bounds both dialing and sending the data.
+// The context only applies to this call, the returned connection is not closed when the context ends.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//	defer cancel()
+//	conn, err := SendInitialTCPContext(ctx, target_addr, data)
+func SendInitialTCPContext(ctx context.Context, target_address string, data []byte) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", target_address)
 	if err != nil {
 		return nil, fmt.Errorf("error dialing TCP: %w", err)
 	}
+	return sendInitial(ctx, conn, data)
+}
 
-	_, err = conn.Write(data)
+// sendInitial writes the first request on a freshly dialed connection, closing the connection if that fails.
+func sendInitial(ctx context.Context, conn net.Conn, data []byte) (net.Conn, error) {
+	stop := watchWrites(ctx, conn)
+	err := WriteFrame(conn, data)
+	stop()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("error sending initial data: %w", err)
+		return nil, fmt.Errorf("error sending initial data: %w", contextError(ctx, err))
 	}
 
 	return conn, nil
 }
 
 // Get_TCP_Reply is used get the reply on a TCP connection. The function pairs well with SendInitialTCP, which is why the function Handle_Single_TCP_Exchange is provided.
-// Something to note is that the buffer size will have to be defined based on how large you're expecting a given reply to be.
+// The reply is read as a single length-prefixed frame, so it is returned whole even if TCP delivered it in pieces.
+// Something to note is that the buffer size is the largest reply that will be accepted, so it will have to be defined based on how large you're expecting a given reply to be.
+// A buffer size of 0 accepts a reply of any size.
+// The reply has to arrive within 5 seconds, use Get_TCP_Reply_Context to choose a different limit.
 //
 // Example:
 //
@@ -94,34 +142,57 @@ func SendInitialTCP(target_address string, data []byte) (net.Conn, error) {
 //	if err != nil {
 //		return nil, fmt.Errorf("error in Get_TCP_Reply: %w", err)
 //	}
-func Get_TCP_Reply(conn net.Conn, buff_size uint16) ([]byte, error) {
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	buffer := make([]byte, buff_size)
-	n, err := conn.Read(buffer)
-	fmt.Printf("Read %d bytes from connection\n", n)
+func Get_TCP_Reply(conn net.Conn, buff_size uint32) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return Get_TCP_Reply_Context(ctx, conn, buff_size)
+}
+
+// Get_TCP_Reply_Context works the same as Get_TCP_Reply but waits for the reply until the context is cancelled or its deadline passes.
+// A context without a deadline waits for as long as it takes.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//	buff, err := Get_TCP_Reply_Context(ctx, conn, buff_size)
+func Get_TCP_Reply_Context(ctx context.Context, conn net.Conn, buff_size uint32) ([]byte, error) {
+	stop := watchReads(ctx, conn)
+	defer stop()
+
+	var header [frameHeaderSize]byte
+	n, err := io.ReadFull(conn, header[:])
+	err = contextError(ctx, err)
 
 	if n == 0 {
 		if err == io.EOF {
 			return nil, fmt.Errorf("connection closed by remote")
+		} else if err == context.Canceled || err == context.DeadlineExceeded {
+			return nil, fmt.Errorf("no data received: %w", err)
 		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 			return nil, fmt.Errorf("read timeout: no data received within deadline")
 		} else if err != nil {
 			return nil, fmt.Errorf("error reading from connection: %v", err)
-		} else {
-			return nil, fmt.Errorf("no data read, but no error reported")
 		}
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("partial read with error: %v", err)
+		return nil, fmt.Errorf("partial read with error: %w", err)
 	}
 
-	return buffer[:n], nil
+	buffer, err := readFrameBody(conn, header, buff_size)
+	if err != nil {
+		return nil, fmt.Errorf("error reading reply: %w", contextError(ctx, err))
+	}
+	defaultLogger().Debug("Read reply", "remote", conn.RemoteAddr(), "bytes", len(buffer))
+
+	return buffer, nil
 
 }
 
 // SendTCPReply is a function to reply to a given TCP connection.
 // The function takes a given connection and data to send and returns an error value, with nil implying there has been no error.
+// Like SendInitialTCP the data is sent as a single length-prefixed frame.
 //
 // Example:
 //
@@ -130,14 +201,29 @@ func Get_TCP_Reply(conn net.Conn, buff_size uint16) ([]byte, error) {
 //		return nil, err
 //	}
 func SendTCPReply(conn net.Conn, data []byte) error {
+	return SendTCPReplyContext(context.Background(), conn, data)
+}
+
+// SendTCPReplyContext works the same as SendTCPReply but gives up once the context is cancelled or its deadline passes.
+// Giving up part way through leaves the connection unusable, as the peer has only received part of the reply.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+//	defer cancel()
+//	err := SendTCPReplyContext(ctx, previous_conn, data)
+func SendTCPReplyContext(ctx context.Context, conn net.Conn, data []byte) error {
 	if conn == nil {
 		return fmt.Errorf("connection is nil")
 	}
 
+	stop := watchWrites(ctx, conn)
+	defer stop()
+
 	// Send the data
-	_, err := conn.Write(data)
+	err := WriteFrame(conn, data)
 	if err != nil {
-		return fmt.Errorf("error sending data: %w", err)
+		return fmt.Errorf("error sending data: %w", contextError(ctx, err))
 	}
 
 	return nil
@@ -145,6 +231,7 @@ func SendTCPReply(conn net.Conn, data []byte) error {
 
 // Handle_Single_TCP_Exchange handles a single exchange of TCP and then closes the connection.
 // You will have to implement more complex exchanges yourself using functions within this package.
+// If the server replies with an error, such as a failed handler behind a Router, it is returned as a *RemoteError.
 //
 // Example:
 //
@@ -152,7 +239,7 @@ func SendTCPReply(conn net.Conn, data []byte) error {
 //	garb := NewGarb(8)
 //	req, _ := networktools.GenerateRequest(garb, 14)
 //	data, _ := networktools.Handle_Single_TCP_Exchange("192.168.1.76:5057", req, 1024)
-func Handle_Single_TCP_Exchange(target_addr string, data []byte, buff_size uint16) ([]byte, error) {
+func Handle_Single_TCP_Exchange(target_addr string, data []byte, buff_size uint32) ([]byte, error) {
 	conn, err := SendInitialTCP(target_addr, data)
 	if err != nil {
 		return nil, fmt.Errorf("error in SendInitialTCP: %w", err)
@@ -163,13 +250,102 @@ func Handle_Single_TCP_Exchange(target_addr string, data []byte, buff_size uint1
 	if err != nil {
 		return nil, fmt.Errorf("error in Get_TCP_Reply: %w", err)
 	}
+	if err := checkReply(buff); err != nil {
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+// Handle_Single_TCP_Exchange_Context works the same as Handle_Single_TCP_Exchange but the context bounds the whole exchange, from dialing to reading the reply.
+//
+// Example:
+//
+//	(Purposefully excluded error handling)
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+//	defer cancel()
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	data, _ := networktools.Handle_Single_TCP_Exchange_Context(ctx, "192.168.1.76:5057", req, 1024)
+func Handle_Single_TCP_Exchange_Context(ctx context.Context, target_addr string, data []byte, buff_size uint32) ([]byte, error) {
+	conn, err := SendInitialTCPContext(ctx, target_addr, data)
+	if err != nil {
+		return nil, fmt.Errorf("error in SendInitialTCP: %w", err)
+	}
+	defer conn.Close() // Ensure the connection is closed when we're done
+
+	buff, err := Get_TCP_Reply_Context(ctx, conn, buff_size)
+	if err != nil {
+		return nil, fmt.Errorf("error in Get_TCP_Reply: %w", err)
+	}
+	if err := checkReply(buff); err != nil {
+		return nil, err
+	}
 
 	return buff, nil
 }
 
+// watchContext applies the context's deadline to the connection and interrupts any blocked read or write if the context is cancelled.
+// The returned function has to be called once the operation is finished, it stops watching and clears the deadline again.
+// Only use it on a connection nothing else is reading from or writing to, use watchReads or watchWrites otherwise.
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetDeadline)
+}
+
+// watchReads works the same as watchContext but only touches the read deadline, leaving writes on the connection alone.
+func watchReads(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetReadDeadline)
+}
+
+// watchWrites works the same as watchContext but only touches the write deadline.
+// A listener replies from a handler while its read loop waits on the same connection, and the read loop's deadline must survive the reply.
+func watchWrites(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetWriteDeadline)
+}
+
+func watchDeadline(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			// A deadline in the past wakes up anything blocked on the connection straight away.
+			setDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		setDeadline(time.Time{})
+	}
+}
+
+// contextError reports the context's error in place of the timeout it caused, so callers can check for context.Canceled or context.DeadlineExceeded.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The connection's deadline can fire a moment before the context notices its own.
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
 // GetPublicIP is a function to get the public IP address of the machine.
 // The function takes no input and returns a string identifying the IP address.
 // It will be noted it is currently relying on a public API so in future there may be bugs / it may not work and will have to be updated.
+// It asks DefaultPublicIPProvider and gives up after 5 seconds, use a PublicIPProvider directly to choose where and how long to ask.
 //
 // Example:
 //
@@ -179,16 +355,9 @@ func Handle_Single_TCP_Exchange(target_addr string, data []byte, buff_size uint1
 //	}
 //	fmt.Printf("Server Global IP is - %s\n", publicIP)
 func GetPublicIP() (string, error) {
-	resp, err := http.Get("https://api.ipify.org")
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	ip, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(ip), nil
+	ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+	defer cancel()
+	return DefaultPublicIPProvider.PublicIP(ctx)
 }
 
 // GetLocalIP is a function to get the Local IP address on the machine's WiFi network.
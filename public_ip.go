@@ -0,0 +1,68 @@
+package networktools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// PublicIPProvider looks up the IP address the machine can be reached on from the internet.
+// Implement it to use your own lookup service, or use StaticPublicIP where the address is already known or there is no internet access.
+type PublicIPProvider interface {
+	PublicIP(ctx context.Context) (string, error)
+}
+
+// DefaultPublicIPProvider is the provider used by GetPublicIP.
+var DefaultPublicIPProvider PublicIPProvider = HTTPPublicIPProvider{URL: "https://api.ipify.org"}
+
+// HTTPPublicIPProvider asks a web service that replies with the caller's IP address as plain text, such as api.ipify.org.
+//
+// Example:
+//
+//	provider := networktools.HTTPPublicIPProvider{URL: "https://ip.internal.example.com"}
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithPublicIPProvider(provider))
+type HTTPPublicIPProvider struct {
+	URL    string
+	Client *http.Client // http.DefaultClient is used when nil
+}
+
+func (p HTTPPublicIPProvider) PublicIP(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("public IP lookup returned %s", resp.Status)
+	}
+
+	ip, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(ip)), nil
+}
+
+// StaticPublicIP is a provider that always returns the same address without any network traffic.
+//
+// Example:
+//
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithPublicIPProvider(networktools.StaticPublicIP("203.0.113.7")))
+type StaticPublicIP string
+
+func (ip StaticPublicIP) PublicIP(ctx context.Context) (string, error) {
+	return string(ip), nil
+}
@@ -0,0 +1,393 @@
+package networktools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNotAcknowledged is returned by ReliableUDPSender.Send when a request was sent as many times as the RetryPolicy allows without being acknowledged.
+// The request may still have arrived, only its acknowledgements may have been lost.
+var ErrNotAcknowledged = errors.New("request was not acknowledged")
+
+const (
+	// reliableWindow is how far ahead of the next expected request a listener will hold requests from one sender.
+	// Anything further ahead is dropped unacknowledged and has to be sent again later.
+	reliableWindow = 256
+
+	// reliablePeerExpiry is how long a listener remembers a sender that has gone quiet.
+	reliablePeerExpiry = 5 * time.Minute
+)
+
+// ReliableUDPSender sends requests over UDP that the listener acknowledges, retransmitting each one until its acknowledgement arrives.
+// Requests are numbered per destination and UDP listeners hand them on in that order, holding back any that overtake an earlier one.
+// Nothing needs setting up on the listener's side, every UDP listener understands reliable requests.
+//
+// The sender can be limited to a set of request types, in which case requests of any other type are sent once like SendUDP does.
+// This lets control commands and telemetry share a sender while only the commands pay for acknowledgements.
+// A ReliableUDPSender is safe to use from several goroutines at once, requests sent concurrently are numbered in the order Send was called.
+//
+// Example:
+//
+//	sender, err := networktools.NewReliableUDPSender("", networktools.DefaultRetryPolicy, RequestMoveCamera, RequestStopCamera)
+//	if err != nil {
+//		return err
+//	}
+//	defer sender.Close()
+//
+//	req, _ := networktools.GenerateRequest(move, RequestMoveCamera)
+//	if err := sender.Send(ctx, camera.Addr, req); err != nil {
+//		fmt.Println("Camera did not get the command:", err)
+//	}
+type ReliableUDPSender struct {
+	conn   *net.UDPConn
+	policy RetryPolicy
+	keys   *SealKeys // Seals requests and opens acknowledgements when set
+	stream uint64
+	types  map[uint32]bool // The request types sent reliably, nil for all of them
+
+	mu      sync.Mutex
+	next    map[netip.AddrPort]uint64 // The last sequence number used for each destination
+	pending map[reliableKey]chan struct{}
+
+	*closer
+}
+
+// reliableKey identifies a request sent reliably, by where it was sent and its sequence number.
+type reliableKey struct {
+	addr     netip.AddrPort
+	sequence uint64
+}
+
+// NewReliableUDPSender opens a socket bound to the local address, empty to let the system choose a port, and sends reliable requests through it.
+// Requests of the given types are sent reliably, with no types given every request is.
+func NewReliableUDPSender(local_address string, policy RetryPolicy, reqTypes ...uint32) (*ReliableUDPSender, error) {
+	return NewSealedReliableUDPSender(local_address, policy, nil, reqTypes...)
+}
+
+// NewSealedReliableUDPSender works the same as NewReliableUDPSender but the sender seals its requests with the keys and only accepts acknowledgements sealed with them, for a listener created with WithSealKeys.
+func NewSealedReliableUDPSender(local_address string, policy RetryPolicy, keys *SealKeys, reqTypes ...uint32) (*ReliableUDPSender, error) {
+	var localAddr *net.UDPAddr
+	if local_address != "" {
+		var err error
+		localAddr, err = net.ResolveUDPAddr("udp", local_address)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving local address: %w", err)
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error opening UDP socket: %w", err)
+	}
+
+	// A random stream ID lets listeners tell a restarted sender, whose numbering starts again from 1, apart from the one before it.
+	var streamID [8]byte
+	if _, err := rand.Read(streamID[:]); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error generating stream ID: %w", err)
+	}
+
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+
+	s := &ReliableUDPSender{
+		conn:    conn,
+		policy:  policy,
+		keys:    keys,
+		stream:  binary.BigEndian.Uint64(streamID[:]) | 1, // Never 0, which means unused
+		next:    make(map[netip.AddrPort]uint64),
+		pending: make(map[reliableKey]chan struct{}),
+		closer:  newCloser(),
+	}
+	if len(reqTypes) > 0 {
+		s.types = make(map[uint32]bool)
+		for _, reqType := range reqTypes {
+			s.types[reqType] = true
+		}
+	}
+
+	go s.readAcks()
+	return s, nil
+}
+
+// Send transmits the request to the target address and, if its type is sent reliably, waits for the listener to acknowledge it.
+// It returns ErrNotAcknowledged once every attempt has gone unacknowledged, or the context's error if the context ends first.
+func (s *ReliableUDPSender) Send(ctx context.Context, target_address string, data []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", target_address)
+	if err != nil {
+		return fmt.Errorf("error resolving address: %w", err)
+	}
+
+	write := sealedWrite(s.keys, sealRequest, func(datagram []byte) error {
+		_, err := s.conn.WriteToUDP(datagram, udpAddr)
+		return err
+	})
+
+	if !s.isReliable(data) {
+		return writeFragmented(data, write)
+	}
+
+	addr := addrKey(udpAddr)
+	acked := make(chan struct{}, 1)
+
+	if err := s.failed(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.next[addr]++
+	key := reliableKey{addr: addr, sequence: s.next[addr]}
+	s.pending[key] = acked
+	base := s.base(addr)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, key)
+		s.mu.Unlock()
+	}()
+
+	_, err = retransmit(ctx, s.policy, s.closer, setSequence(data, s.stream, key.sequence, base), write, acked, ErrNotAcknowledged)
+	return err
+}
+
+// LocalAddr returns the address the sender's socket is bound to, which is where acknowledgements are sent.
+func (s *ReliableUDPSender) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close closes the socket and fails every Send still waiting for an acknowledgement with ErrClientClosed.
+func (s *ReliableUDPSender) Close() error {
+	s.fail(ErrClientClosed)
+	return s.conn.Close()
+}
+
+// base returns the lowest sequence number still waiting to be acknowledged by the destination. Every request before it has been acknowledged or given up on.
+// The caller must hold s.mu.
+func (s *ReliableUDPSender) base(addr netip.AddrPort) uint64 {
+	base := s.next[addr]
+	for key := range s.pending {
+		if key.addr == addr && key.sequence < base {
+			base = key.sequence
+		}
+	}
+	return base
+}
+
+// isReliable reports whether the request should be sent reliably, based on its type.
+func (s *ReliableUDPSender) isReliable(data []byte) bool {
+	if s.types == nil {
+		return true
+	}
+	reqType, ok := requestType(data)
+	if !ok {
+		// Let the listener decide what to make of it, but make sure it gets there.
+		return true
+	}
+	return s.types[reqType]
+}
+
+func (s *ReliableUDPSender) readAcks() {
+	buffer := make([]byte, maxUDPDatagramSize)
+
+	for {
+		n, from, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.fail(fmt.Errorf("%w: %v", ErrClientClosed, err))
+				return
+			}
+			defaultLogger().Debug("Error reading acknowledgement", "error", err)
+			continue
+		}
+
+		datagram := buffer[:n]
+		if s.keys != nil {
+			if datagram, err = s.keys.OpenReply(datagram); err != nil {
+				continue
+			}
+		}
+
+		req, err := DeserialiseRequest(datagram)
+		if err != nil || req.Type != RequestAck || req.stream != s.stream {
+			// Replies and anything else that isn't an acknowledgement for this sender are of no interest.
+			continue
+		}
+
+		s.mu.Lock()
+		acked, ok := s.pending[reliableKey{addr: addrKey(from), sequence: req.ack}]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case acked <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// addrKey turns a UDP address into a map key, treating an IPv4 address and its IPv6 mapped form as the same address.
+func addrKey(addr net.Addr) netip.AddrPort {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return netip.AddrPort{}
+	}
+	addrPort := udpAddr.AddrPort()
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
+
+// reliablePeer is what a listener knows about one ReliableUDPSender.
+type reliablePeer struct {
+	stream   uint64
+	next     uint64                    // The sequence number to hand on next
+	held     map[uint64]UDPNetworkData // Requests that arrived ahead of the next one
+	gapSince time.Time                 // When the listener started waiting for the next request while holding later ones
+	lastSeen time.Time
+}
+
+// reliableReceiver acknowledges reliable requests for a UDP listener and puts them back in order.
+// It is only used from the listener's read loop, so it needs no locking.
+type reliableReceiver struct {
+	peers map[netip.AddrPort]*reliablePeer
+}
+
+func newReliableReceiver() *reliableReceiver {
+	return &reliableReceiver{peers: make(map[netip.AddrPort]*reliablePeer)}
+}
+
+// receive acknowledges a reliable request and returns the requests that are now ready to be handed on, in order.
+func (r *reliableReceiver) receive(data UDPNetworkData, cfg ServerConfig, now time.Time) []UDPNetworkData {
+	key := addrKey(data.Addr)
+	req := data.Request
+
+	peer := r.peers[key]
+	if peer == nil || peer.stream != req.stream {
+		// A sender the listener doesn't know, because it is new or the listener restarted or forgot it, carries on from the first request it is still waiting on.
+		next := req.base
+		if next == 0 {
+			next = 1
+		}
+		peer = &reliablePeer{stream: req.stream, next: next, held: make(map[uint64]UDPNetworkData)}
+		r.peers[key] = peer
+	}
+	peer.lastSeen = now
+
+	switch {
+	case req.Sequence < peer.next:
+		// Already handed on, its acknowledgement must have been lost.
+	case req.Sequence-peer.next >= reliableWindow:
+		cfg.Logger.Warn("Dropping reliable request too far ahead of the next one", "remote", data.Addr, "sequence", req.Sequence, "next", peer.next)
+		return nil
+	default:
+		peer.held[req.Sequence] = data
+	}
+
+	r.acknowledge(data, cfg)
+	return peer.release(now)
+}
+
+// acknowledge tells the sender its request arrived, from the socket the request arrived on.
+func (r *reliableReceiver) acknowledge(data UDPNetworkData, cfg ServerConfig) {
+	ack, err := proto.Marshal(&pb.Request{Type: RequestAck, Stream: data.Request.stream, Ack: data.Request.Sequence})
+	if err == nil {
+		err = data.writeDatagram(ack)
+	}
+	if err != nil {
+		cfg.Logger.Warn("Error acknowledging request", "remote", data.Addr, "sequence", data.Request.Sequence, "error", err)
+		cfg.report("reply", data.Addr, err)
+	}
+}
+
+// sweep gives up waiting on requests that have been missing for longer than the gap timeout, returning the held requests that can now be handed on.
+// It also forgets senders that have gone quiet.
+func (r *reliableReceiver) sweep(cfg ServerConfig, now time.Time) []UDPNetworkData {
+	var ready []UDPNetworkData
+	for key, peer := range r.peers {
+		if len(peer.held) == 0 {
+			if now.Sub(peer.lastSeen) > reliablePeerExpiry {
+				delete(r.peers, key)
+			}
+			continue
+		}
+		if cfg.ReliableGapTimeout == 0 || now.Sub(peer.gapSince) < cfg.ReliableGapTimeout {
+			continue
+		}
+
+		var skipTo uint64
+		for sequence := range peer.held {
+			if skipTo == 0 || sequence < skipTo {
+				skipTo = sequence
+			}
+		}
+		cfg.Logger.Warn("Skipping reliable requests that never arrived", "remote", key, "from", peer.next, "to", skipTo-1)
+		peer.next = skipTo
+		ready = append(ready, peer.release(now)...)
+	}
+	return ready
+}
+
+// release returns the held requests that follow on from the last one handed on.
+func (p *reliablePeer) release(now time.Time) []UDPNetworkData {
+	var ready []UDPNetworkData
+	for {
+		data, ok := p.held[p.next]
+		if !ok {
+			break
+		}
+		delete(p.held, p.next)
+		ready = append(ready, data)
+		p.next++
+	}
+
+	if len(p.held) == 0 {
+		p.gapSince = time.Time{}
+	} else if len(ready) > 0 || p.gapSince.IsZero() {
+		p.gapSince = now
+	}
+	return ready
+}
+
+// peerQueues runs the handlers for reliable requests from the same sender one at a time, so a router sees them in the order they were sent.
+type peerQueues struct {
+	mu    sync.Mutex
+	tails map[netip.AddrPort]chan struct{} // Closed once the last handler queued for each sender has finished
+}
+
+func newPeerQueues() *peerQueues {
+	return &peerQueues{tails: make(map[netip.AddrPort]chan struct{})}
+}
+
+// run calls fn in a new goroutine once every handler queued before it for the same sender has finished.
+func (q *peerQueues) run(addr net.Addr, fn func()) {
+	key := addrKey(addr)
+	done := make(chan struct{})
+
+	q.mu.Lock()
+	previous := q.tails[key]
+	q.tails[key] = done
+	q.mu.Unlock()
+
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		fn()
+		close(done)
+
+		q.mu.Lock()
+		if q.tails[key] == done {
+			delete(q.tails, key)
+		}
+		q.mu.Unlock()
+	}()
+}
@@ -0,0 +1,157 @@
+package networktools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Handler processes a single request and returns the reply to send back to the sender.
+// The reply should be serialised with GenerateRequest. Returning a nil reply sends nothing back.
+// Returning an error sends the sender a RequestError reply instead, see RemoteError.
+type Handler func(ctx context.Context, req Request_Type, addr net.Addr) ([]byte, error)
+
+// Router sends each request to the handler registered for its request type, so consumers don't have to write their own switch on Request.Type.
+// Replies are tagged with the request's correlation ID so they find their way back to a waiting Client.
+// Requests with a type that has no handler go to the fallback handler, if one is set.
+// Otherwise a request that came over TCP gets a CodeNoHandler error reply, and one that came over UDP is dropped,
+// as a spoofed datagram answered with a larger reply would let anyone bounce traffic off the listener.
+// A Router can be shared between a TCP and a UDP listener and handlers can be registered while it is in use.
+//
+// Example:
+//
+//	router := networktools.NewRouter()
+//	router.Handle(RequestCamera, func(ctx context.Context, req networktools.Request_Type, addr net.Addr) ([]byte, error) {
+//		var c Camera
+//		if err := networktools.DeserialiseData(&c, req.Payload); err != nil {
+//			return nil, err
+//		}
+//		cameraMap.addCamera(c)
+//		return networktools.GenerateRequest(nil, RequestSuccessful)
+//	})
+//	listener, err := networktools.Create_TCP_Listener_With_Router(8080, router)
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[uint32]Handler
+	fallback Handler
+}
+
+// NewRouter creates a Router with no handlers registered.
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[uint32]Handler),
+	}
+}
+
+// Handle registers the handler for a request type, replacing any handler already registered for it.
+// It panics if the request type is reserved for the library, see ReservedTypeStart.
+func (r *Router) Handle(reqType uint32, handler Handler) {
+	if IsReservedType(reqType) {
+		panic(fmt.Sprintf("networktools: request type %d is reserved for the library", reqType))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[reqType] = handler
+}
+
+// HandleFallback registers the handler used for request types that have no handler of their own.
+func (r *Router) HandleFallback(handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+// Dispatch calls the handler registered for the request's type and returns its reply.
+// The listeners created with a router call this for you, it is exported so requests received some other way can be routed too.
+// The sender's address is available to the handler through PeerAddr as well as its addr argument,
+// and the request's headers through ContextHeaders, so a Client or UDPClient called with the handler's context passes them on.
+// Pings are answered by the router itself.
+func (r *Router) Dispatch(ctx context.Context, req Request_Type, addr net.Addr) ([]byte, error) {
+	if req.Type == RequestPing {
+		return generateRequest(nil, RequestPing)
+	}
+	ctx = context.WithValue(ctx, peerAddrKey{}, addr)
+	if len(req.Headers) > 0 {
+		ctx = ContextWithHeaders(ctx, req.Headers)
+	}
+
+	r.mu.RLock()
+	handler, ok := r.handlers[req.Type]
+	if !ok {
+		handler = r.fallback
+	}
+	r.mu.RUnlock()
+
+	if handler == nil {
+		return nil, &RemoteError{Code: CodeNoHandler, Message: fmt.Sprintf("no handler registered for request type %d", req.Type)}
+	}
+	return handler(ctx, req, addr)
+}
+
+type peerAddrKey struct{}
+
+// PeerAddr returns the address of whoever sent the request being handled, or nil if the context did not come from a Router.
+// It is mostly useful inside typed handlers registered with Handle, which are not given the address directly.
+func PeerAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(peerAddrKey{}).(net.Addr)
+	return addr
+}
+
+func (r *Router) serveTCP(data TCPNetworkData, cfg ServerConfig, tracker *tracker) {
+	// Requests from a Client can be answered out of order, so they don't have to wait for the ones before them.
+	if data.Request.CorrelationID != 0 {
+		tracker.startHandler(data.Conn)
+		go func() {
+			defer tracker.finishHandler(data.Conn)
+			r.replyTCP(tracker.ctx, data, cfg)
+		}()
+		return
+	}
+	r.replyTCP(tracker.ctx, data, cfg)
+}
+
+func (r *Router) replyTCP(ctx context.Context, data TCPNetworkData, cfg ServerConfig) {
+	if data.Peer != nil {
+		ctx = context.WithValue(ctx, peerCertificateKey{}, data.Peer)
+	}
+	reply, err := r.Dispatch(ctx, data.Request, data.Get_Addr())
+	if err != nil {
+		cfg.Logger.Error("Error handling request", "remote", data.Get_Addr(), "type", data.Request.Type, "error", err)
+		cfg.report("handle", data.Get_Addr(), err)
+		reply = errorReply(data.Request, err)
+	}
+	if reply == nil {
+		return
+	}
+
+	writeCtx, cancel := timeoutContext(cfg.WriteTimeout)
+	defer cancel()
+	if err := SendTCPReplyContext(writeCtx, data.Conn, CorrelateReply(reply, data.Request)); err != nil {
+		cfg.Logger.Warn("Error sending reply", "remote", data.Get_Addr(), "type", data.Request.Type, "bytes", len(reply), "error", err)
+		cfg.report("reply", data.Get_Addr(), err)
+	}
+}
+
+func (r *Router) serveUDP(ctx context.Context, data UDPNetworkData, cfg ServerConfig) {
+	reply, err := r.Dispatch(ctx, data.Request, data.Addr)
+	if err != nil {
+		cfg.Logger.Error("Error handling request", "remote", data.Addr, "type", data.Request.Type, "error", err)
+		cfg.report("handle", data.Addr, err)
+		var remoteErr *RemoteError
+		if errors.As(err, &remoteErr) && remoteErr.Code == CodeNoHandler {
+			return
+		}
+		reply = errorReply(data.Request, err)
+	}
+	if reply == nil {
+		return
+	}
+
+	data.conn.SetWriteDeadline(deadline(cfg.WriteTimeout))
+	if err := data.Reply(reply); err != nil {
+		cfg.Logger.Warn("Error sending reply", "remote", data.Addr, "type", data.Request.Type, "bytes", len(reply), "error", err)
+		cfg.report("reply", data.Addr, err)
+	}
+}
@@ -0,0 +1,236 @@
+package networktools
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrUnsealFailed is returned for a sealed datagram that can't be trusted: it was tampered with, sealed with a key we don't have, too old, or a replay.
+var ErrUnsealFailed = errors.New("datagram could not be unsealed")
+
+// DefaultSealMaxAge is how far a sealed datagram's timestamp may be from the receiver's clock before it is rejected.
+// The clocks of both ends have to agree to well within this.
+const DefaultSealMaxAge = 30 * time.Second
+
+const (
+	sealNonceSize  = 12
+	sealHeaderSize = 4 + 8 + sealNonceSize // Key ID, timestamp and nonce, all authenticated along with the payload
+
+	// maxSeenNonces caps the replay cache. Once it is full new datagrams are rejected until old entries expire,
+	// which only datagrams sealed with one of our keys can cause.
+	maxSeenNonces = 1 << 20
+)
+
+// sealDirection says which way a sealed datagram is going. It is authenticated along with the header but not sent,
+// so a datagram sealed one way fails to open the other way and a listener's reply can't be sent back to it as a request.
+type sealDirection byte
+
+const (
+	sealRequest sealDirection = iota + 1 // To a listener
+	sealReply                            // From a listener, replies and acknowledgements
+)
+
+// SealKeys holds the pre-shared keys used to seal UDP datagrams, so only holders of a key can send them or read them.
+// Each datagram is encrypted and authenticated with AES-GCM under the current key, and carries the key's ID so the receiver knows which key to open it with.
+// A timestamp and a random nonce in each datagram let the receiver reject datagrams that are old or have been seen before.
+// Requests and replies are sealed differently, so neither can be passed off as the other.
+//
+// Keys are rotated by adding the new key everywhere, switching senders over with UseKey, then removing the old key once nothing uses it.
+// A SealKeys is safe to use from several goroutines at once, and can be shared by a listener and the senders talking to it.
+//
+// Example:
+//
+//	keys, err := networktools.NewSealKeys(1, map[uint32][]byte{1: key})
+//	if err != nil {
+//		return err
+//	}
+//	request_channel, listener, err := Create_UDP_Listener(8080, networktools.WithSealKeys(keys))
+type SealKeys struct {
+	mu      sync.RWMutex
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+
+	seenMu    sync.Mutex
+	seen      map[[sealNonceSize]byte]time.Time // Nonces of recently opened datagrams and their timestamps
+	lastSweep time.Time
+	maxAge    time.Duration
+}
+
+// NewSealKeys creates a key set from keys of 16, 24 or 32 bytes (AES-128, AES-192 or AES-256), sealing with the key with ID current.
+func NewSealKeys(current uint32, keys map[uint32][]byte) (*SealKeys, error) {
+	k := &SealKeys{
+		aeads:  make(map[uint32]cipher.AEAD),
+		seen:   make(map[[sealNonceSize]byte]time.Time),
+		maxAge: DefaultSealMaxAge,
+	}
+	for id, key := range keys {
+		if err := k.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.UseKey(current); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AddKey adds a key that datagrams can be opened with. It is not used for sealing until UseKey is called with its ID.
+func (k *SealKeys) AddKey(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("error creating cipher for key %d: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("error creating cipher for key %d: %w", id, err)
+	}
+
+	k.mu.Lock()
+	k.aeads[id] = aead
+	k.mu.Unlock()
+	return nil
+}
+
+// UseKey seals every datagram from now on with the key with the given ID, which has to have been added already.
+func (k *SealKeys) UseKey(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.aeads[id]; !ok {
+		return fmt.Errorf("no key with ID %d", id)
+	}
+	k.current = id
+	return nil
+}
+
+// RemoveKey stops datagrams sealed with the key being accepted. The key currently used for sealing can't be removed.
+func (k *SealKeys) RemoveKey(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return fmt.Errorf("key %d is in use for sealing", id)
+	}
+	delete(k.aeads, id)
+	return nil
+}
+
+// Seal encrypts and authenticates a request datagram under the current key, for sending to a listener. The listeners and senders do this for you when given the keys.
+func (k *SealKeys) Seal(datagram []byte) ([]byte, error) {
+	return k.seal(datagram, sealRequest)
+}
+
+// SealReply works the same as Seal but seals a datagram sent by a listener, such as a reply, which only OpenReply opens.
+func (k *SealKeys) SealReply(datagram []byte) ([]byte, error) {
+	return k.seal(datagram, sealReply)
+}
+
+func (k *SealKeys) seal(datagram []byte, direction sealDirection) ([]byte, error) {
+	k.mu.RLock()
+	id := k.current
+	aead := k.aeads[id]
+	k.mu.RUnlock()
+
+	sealed := make([]byte, sealHeaderSize, sealHeaderSize+len(datagram)+aead.Overhead())
+	binary.BigEndian.PutUint32(sealed[0:4], id)
+	binary.BigEndian.PutUint64(sealed[4:12], uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(sealed[12:sealHeaderSize]); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return aead.Seal(sealed, sealed[12:sealHeaderSize], datagram, additionalData(sealed[:sealHeaderSize], direction)), nil
+}
+
+// Open checks a request datagram sealed with Seal and returns what was sealed, or an error wrapping ErrUnsealFailed if it can't be trusted.
+// A datagram can only be opened once, so Open rejects replays of it.
+// The datagram's memory is reused for the result.
+func (k *SealKeys) Open(sealed []byte) ([]byte, error) {
+	return k.open(sealed, sealRequest)
+}
+
+// OpenReply works the same as Open but opens a datagram sealed by a listener with SealReply.
+func (k *SealKeys) OpenReply(sealed []byte) ([]byte, error) {
+	return k.open(sealed, sealReply)
+}
+
+func (k *SealKeys) open(sealed []byte, direction sealDirection) ([]byte, error) {
+	if len(sealed) < sealHeaderSize {
+		return nil, fmt.Errorf("%w: datagram of %d bytes is too short", ErrUnsealFailed, len(sealed))
+	}
+
+	id := binary.BigEndian.Uint32(sealed[0:4])
+	k.mu.RLock()
+	aead, ok := k.aeads[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %d", ErrUnsealFailed, id)
+	}
+
+	now := time.Now()
+	timestamp := time.Unix(0, int64(binary.BigEndian.Uint64(sealed[4:12])))
+	if age := now.Sub(timestamp); age > k.maxAge || age < -k.maxAge {
+		return nil, fmt.Errorf("%w: timestamp is %s away from now", ErrUnsealFailed, age.Round(time.Millisecond))
+	}
+
+	header := sealed[:sealHeaderSize]
+	datagram, err := aead.Open(sealed[sealHeaderSize:sealHeaderSize], header[12:], sealed[sealHeaderSize:], additionalData(header, direction))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsealFailed, err)
+	}
+
+	// The nonce is only recorded once the datagram has proved genuine, so nobody without a key can fill up the cache.
+	var nonce [sealNonceSize]byte
+	copy(nonce[:], header[12:])
+	if err := k.remember(nonce, timestamp, now); err != nil {
+		return nil, err
+	}
+	return datagram, nil
+}
+
+// additionalData is what is authenticated without being encrypted: the datagram's header and the direction it is going.
+func additionalData(header []byte, direction sealDirection) []byte {
+	return append(append(make([]byte, 0, len(header)+1), header...), byte(direction))
+}
+
+// remember records the nonce of an opened datagram, failing if it has been seen before.
+// Nonces are forgotten once their datagram is too old to pass the timestamp check anyway.
+func (k *SealKeys) remember(nonce [sealNonceSize]byte, timestamp time.Time, now time.Time) error {
+	k.seenMu.Lock()
+	defer k.seenMu.Unlock()
+
+	if now.Sub(k.lastSweep) > k.maxAge {
+		k.lastSweep = now
+		for seen, at := range k.seen {
+			if now.Sub(at) > k.maxAge {
+				delete(k.seen, seen)
+			}
+		}
+	}
+
+	if _, ok := k.seen[nonce]; ok {
+		return fmt.Errorf("%w: replayed datagram", ErrUnsealFailed)
+	}
+	if len(k.seen) >= maxSeenNonces {
+		return fmt.Errorf("%w: too many recent datagrams to check for replays", ErrUnsealFailed)
+	}
+	k.seen[nonce] = timestamp
+	return nil
+}
+
+// sealedWrite wraps a function that writes a datagram so the datagram is sealed first for the direction it is going, if there are keys to seal it with.
+func sealedWrite(keys *SealKeys, direction sealDirection, write func([]byte) error) func([]byte) error {
+	if keys == nil {
+		return write
+	}
+	return func(datagram []byte) error {
+		sealed, err := keys.seal(datagram, direction)
+		if err != nil {
+			return err
+		}
+		return write(sealed)
+	}
+}
@@ -0,0 +1,330 @@
+package networktools
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+)
+
+// IPVersion selects which IP version a listener binds to.
+type IPVersion uint8
+
+const (
+	IPAny IPVersion = iota // Both IPv4 and IPv6, wherever the host supports them
+	IPv4                   // IPv4 only
+	IPv6                   // IPv6 only
+)
+
+// network returns the network name understood by the net package, e.g. "tcp4" for IPv4 over TCP.
+func (v IPVersion) network(transport string) string {
+	switch v {
+	case IPv4:
+		return transport + "4"
+	case IPv6:
+		return transport + "6"
+	default:
+		return transport
+	}
+}
+
+// ServerConfig holds everything about a listener that can be changed from the defaults.
+// You don't usually build one yourself, instead pass ServerOptions to the listener constructors and they are applied on top of DefaultServerConfig.
+type ServerConfig struct {
+	Host      string    // Address to bind to, empty for all interfaces
+	IPVersion IPVersion // Which IP version to listen on
+
+	TLSConfig *tls.Config // Makes a TCP listener accept TLS connections only, nil for plain TCP
+	SealKeys  *SealKeys   // Makes a UDP listener accept sealed datagrams only and seal its replies, nil for plain UDP
+	Handshake *Hello      // Makes a TCP listener start every connection with a handshake, nil for no handshake
+
+	MaxMessageSize uint32 // Largest request accepted, 0 for no limit. UDP requests bigger than a datagram arrive in fragments (see FragmentRequest)
+	ChannelBuffer  int    // How many requests the request channel holds before the listener waits for you to read them
+
+	ReadTimeout  time.Duration // How long a TCP request may take to arrive once it has started, 0 for no limit
+	IdleTimeout  time.Duration // How long a TCP connection may go without sending a request before it is closed, 0 for no limit
+	WriteTimeout time.Duration // How long the listener spends sending a reply before giving up, 0 for no limit
+	PollInterval time.Duration // How often the listener checks whether it has been stopped
+
+	ReliableGapTimeout time.Duration // How long a UDP listener holds back reliable requests waiting for an earlier one that is missing, 0 to wait forever
+	FragmentTimeout    time.Duration // How long a UDP listener waits for the rest of a fragmented request, 0 to wait forever
+	MaxFragmentMemory  int           // Most bytes a UDP listener holds across fragmented requests it is still waiting on, 0 for no limit. Should be at least MaxMessageSize
+
+	Logger  Logger      // Where the listener reports what it is doing, the default logger when nil
+	OnError func(error) // Called with a *ListenerError whenever a request is dropped or a connection fails, nil to ignore them
+
+	AnnounceIPs      bool             // Whether to log the port and IP addresses the server can be reached on at startup
+	PublicIPProvider PublicIPProvider // Where the announced public IP is looked up, nil to skip the lookup
+	PublicIPTimeout  time.Duration    // How long the public IP lookup may take
+
+	stats *listenerStats
+}
+
+// ServerOption changes a single setting of a listener, see the With functions below.
+type ServerOption func(*ServerConfig)
+
+// DefaultServerConfig returns the configuration used by a listener that was given no options.
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		IPVersion:          IPAny,
+		MaxMessageSize:     DefaultMaxMessageSize,
+		ReadTimeout:        5 * time.Second,
+		WriteTimeout:       5 * time.Second,
+		PollInterval:       time.Second,
+		ReliableGapTimeout: 10 * time.Second,
+		FragmentTimeout:    5 * time.Second,
+		MaxFragmentMemory:  DefaultMaxMessageSize, // Room for a request of the largest size accepted
+		AnnounceIPs:        true,
+		PublicIPTimeout:    2 * time.Second,
+	}
+}
+
+func newServerConfig(opts []ServerOption) ServerConfig {
+	cfg := DefaultServerConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = defaultLogger()
+	}
+	cfg.stats = &listenerStats{}
+	return cfg
+}
+
+// address returns the host and port the listener should bind to.
+func (cfg ServerConfig) address(port uint16) string {
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(int(port)))
+}
+
+// WithHost binds the listener to a single address instead of all interfaces.
+//
+// Example:
+//
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithHost("127.0.0.1"))
+func WithHost(host string) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Host = host
+	}
+}
+
+// WithTLS makes a TCP listener accept TLS connections with the given configuration, see NewServerTLSConfig.
+// Set ClientAuth and ClientCAs in the configuration to require client certificates (mutual TLS).
+//
+// Example:
+//
+//	config, _ := networktools.NewServerTLSConfig("server.crt", "server.key", "clients-ca.crt")
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithTLS(config))
+func WithTLS(config *tls.Config) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.TLSConfig = config
+	}
+}
+
+// WithSealKeys makes a UDP listener accept only datagrams sealed with one of the keys, and seal its replies with them.
+// Anything else is dropped before it reaches the request channel or router, and reported to the error handler.
+//
+// Example:
+//
+//	keys, _ := networktools.NewSealKeys(1, map[uint32][]byte{1: key})
+//	request_channel, listener, err := Create_UDP_Listener(8080, networktools.WithSealKeys(keys))
+func WithSealKeys(keys *SealKeys) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.SealKeys = keys
+	}
+}
+
+// WithHandshake makes a TCP listener start every connection with a handshake, refusing clients that are incompatible with the Hello.
+// Clients then have to connect with ClientHandshake or Dial_Client_With_Handshake. What each client announced is available as TCPNetworkData.Hello.
+//
+// Example:
+//
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithHandshake(networktools.Hello{
+//		AppID:    "camera-control",
+//		Features: []string{networktools.FeatureCompression},
+//	}))
+func WithHandshake(hello Hello) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Handshake = &hello
+	}
+}
+
+// WithIPVersion restricts the listener to IPv4 or IPv6.
+func WithIPVersion(version IPVersion) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.IPVersion = version
+	}
+}
+
+// WithMaxMessageSize sets the largest request the listener will accept.
+// Requests are read whole regardless of size, so the limit exists only to stop a misbehaving client from making the server allocate arbitrary amounts of memory.
+// It applies to compressed payloads once decompressed too, see WithCompression.
+// A size of 0 removes the limit, for TCP and UDP alike.
+func WithMaxMessageSize(size uint32) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MaxMessageSize = size
+	}
+}
+
+// WithChannelBuffer lets the request channel hold requests that haven't been read yet, so a burst of requests doesn't stall the listener.
+func WithChannelBuffer(depth int) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ChannelBuffer = depth
+	}
+}
+
+// WithReadTimeout sets how long a TCP request may take to arrive once its first bytes have been received.
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ReadTimeout = timeout
+	}
+}
+
+// WithIdleTimeout closes TCP connections that go this long without sending a request.
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.IdleTimeout = timeout
+	}
+}
+
+// WithWriteTimeout sets how long the listener spends sending a reply before giving up.
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.WriteTimeout = timeout
+	}
+}
+
+// WithPollInterval sets how often the listener checks whether it has been stopped, which is how long Stop can take to be noticed.
+func WithPollInterval(interval time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.PollInterval = interval
+	}
+}
+
+// WithReliableGapTimeout sets how long a UDP listener holds back reliable requests while waiting for an earlier one that is missing, after which it gives up on the missing one.
+// It should be longer than the senders spend retransmitting a request, otherwise a request that is merely late gets skipped.
+func WithReliableGapTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.ReliableGapTimeout = timeout
+	}
+}
+
+// WithFragmentTimeout sets how long a UDP listener waits for the rest of a fragmented request before dropping the fragments it has.
+func WithFragmentTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.FragmentTimeout = timeout
+	}
+}
+
+// WithMaxFragmentMemory caps how many bytes a UDP listener holds across the fragmented requests it is still waiting on.
+// When a new fragment would go over the cap the oldest incomplete requests are dropped to make room.
+// Every fragment and incomplete request counts for a little more than the bytes it carries, for the memory it takes to keep track of it.
+// A request bigger than the cap can never be put back together, so it also caps the size of fragmented requests, whatever WithMaxMessageSize allows.
+func WithMaxFragmentMemory(size int) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.MaxFragmentMemory = size
+	}
+}
+
+// WithLogger sends everything the listener reports to the logger instead of the default logger.
+func WithLogger(logger Logger) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.Logger = logger
+	}
+}
+
+// WithErrorHandler calls the function with a *ListenerError every time something goes wrong while the listener is running.
+// It is called from the listener's goroutines, so it should return quickly and be safe to call concurrently.
+//
+// Example:
+//
+//	errs := make(chan error, 16)
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithErrorHandler(func(err error) {
+//		select {
+//		case errs <- err:
+//		default:
+//		}
+//	}))
+func WithErrorHandler(handler func(error)) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.OnError = handler
+	}
+}
+
+// WithAnnounceIPs turns the startup message with the server's port and IP addresses on or off.
+func WithAnnounceIPs(announce bool) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.AnnounceIPs = announce
+	}
+}
+
+// WithPublicIPProvider looks up the server's public IP with the provider so it can be announced at startup.
+// The lookup is off by default since it usually means a request to a service on the internet.
+//
+// Example:
+//
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithPublicIPProvider(networktools.DefaultPublicIPProvider))
+func WithPublicIPProvider(provider PublicIPProvider) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.PublicIPProvider = provider
+	}
+}
+
+// WithPublicIPTimeout sets how long the public IP lookup may take before it is given up on.
+func WithPublicIPTimeout(timeout time.Duration) ServerOption {
+	return func(cfg *ServerConfig) {
+		cfg.PublicIPTimeout = timeout
+	}
+}
+
+// announce logs where the server can be reached, if the configuration asks for it.
+// It runs alongside the listener so a slow public IP lookup doesn't hold up accepting requests.
+func (cfg ServerConfig) announce(transport string, addr net.Addr) {
+	if !cfg.AnnounceIPs {
+		return
+	}
+
+	cfg.Logger.Info("Server listening", "transport", transport, "addr", addr)
+
+	if cfg.PublicIPProvider != nil {
+		ctx, cancel := timeoutContext(cfg.PublicIPTimeout)
+		publicIP, err := cfg.PublicIPProvider.PublicIP(ctx)
+		cancel()
+		if err != nil {
+			cfg.Logger.Warn("Error getting public IP", "error", err)
+		} else {
+			cfg.Logger.Info("Server global IP", "ip", publicIP)
+		}
+	}
+
+	localIP, err := GetLocalIP()
+	if err != nil {
+		cfg.Logger.Warn("Error getting local IP address", "error", err)
+	} else {
+		cfg.Logger.Info("Server local IP", "ip", localIP)
+	}
+}
+
+// report passes an error that happened while the listener was running to the error handler, if there is one.
+func (cfg ServerConfig) report(op string, remote net.Addr, err error) {
+	cfg.stats.failed(err)
+	if cfg.OnError != nil {
+		cfg.OnError(&ListenerError{Op: op, Remote: remote, Err: err})
+	}
+}
+
+// timeoutContext returns a context that expires after the timeout, with a timeout of 0 meaning it never expires.
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// deadline turns a timeout into a deadline for the connection, with a timeout of 0 meaning no deadline.
+func deadline(timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,169 @@
+package networktools
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// tracker keeps count of the goroutines a listener has started, so Shutdown can wait for them to finish or cut them off.
+// It counts one goroutine for the read or accept loop, one for every open TCP connection and one for every handler running on its own.
+type tracker struct {
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	stopping bool
+	conns    map[net.Conn]*trackedConn
+	handlers int
+
+	// ctx is given to handlers and is cancelled when a shutdown runs out of time.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	drained   chan struct{}
+	onDrained func()
+}
+
+// trackedConn is what the tracker knows about an open connection.
+type trackedConn struct {
+	idle     bool // Waiting for its next request
+	handlers int  // Handlers running on their own for requests read from it, which still have to reply on it
+	done     bool // The read loop has finished with it, so it is closed once the last handler finishes
+}
+
+func newTracker(onDrained func()) *tracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &tracker{
+		conns:     make(map[net.Conn]*trackedConn),
+		ctx:       ctx,
+		cancel:    cancel,
+		drained:   make(chan struct{}),
+		onDrained: onDrained,
+	}
+}
+
+// addConn starts tracking a connection. It returns false once the listener is stopping, in which case the connection should be closed.
+func (t *tracker) addConn(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopping {
+		return false
+	}
+	t.conns[conn] = &trackedConn{}
+	t.wg.Add(1)
+	return true
+}
+
+// awaitRequest marks the connection idle and sets the deadline for its next request to arrive.
+// It returns false once the listener is stopping, in which case no more requests should be read.
+// The deadline is set here, under the lock, so that it can't overwrite the one stop sets to wake idle connections.
+func (t *tracker) awaitRequest(conn net.Conn, deadline time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopping {
+		return false
+	}
+	t.conns[conn].idle = true
+	conn.SetReadDeadline(deadline)
+	return true
+}
+
+// busy marks the connection as part way through a request, which stop leaves to finish.
+func (t *tracker) busy(conn net.Conn) {
+	t.mu.Lock()
+	t.conns[conn].idle = false
+	t.mu.Unlock()
+}
+
+// removeConn is called once the read loop has finished with the connection.
+// The connection is closed and no longer tracked straight away, unless handlers still have to reply on it, in which case the last of them does that.
+func (t *tracker) removeConn(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.conns[conn]; ok {
+		state.done = true
+		t.releaseConn(conn, state)
+	}
+}
+
+// releaseConn closes the connection once nothing is using it any more. It has to be called with the lock held.
+func (t *tracker) releaseConn(conn net.Conn, state *trackedConn) {
+	if !state.done || state.handlers > 0 {
+		return
+	}
+	conn.Close()
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+// startHandler counts a handler that runs in its own goroutine. It has to be called from a goroutine the tracker already counts.
+// conn is the connection the handler replies on, which is kept open until it finishes, or nil if it doesn't reply on a tracked connection.
+func (t *tracker) startHandler(conn net.Conn) {
+	t.mu.Lock()
+	t.handlers++
+	if state, ok := t.conns[conn]; ok {
+		state.handlers++
+	}
+	t.mu.Unlock()
+	t.wg.Add(1)
+}
+
+func (t *tracker) finishHandler(conn net.Conn) {
+	t.mu.Lock()
+	t.handlers--
+	if state, ok := t.conns[conn]; ok {
+		state.handlers--
+		t.releaseConn(conn, state)
+	}
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+func (t *tracker) isStopping() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stopping
+}
+
+// stop wakes up every connection waiting for a request so it can notice the listener is stopping, and calls onDrained once everything has finished.
+// Connections part way through a request are left to finish it.
+func (t *tracker) stop() {
+	t.mu.Lock()
+	t.stopping = true
+	for conn, state := range t.conns {
+		if state.idle && !state.done {
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	t.mu.Unlock()
+
+	go func() {
+		t.wg.Wait()
+		t.cancel()
+		if t.onDrained != nil {
+			t.onDrained()
+		}
+		close(t.drained)
+	}()
+}
+
+// wait blocks until everything has finished or the context ends, in which case it cancels the handlers, closes the connections still open and reports them.
+func (t *tracker) wait(ctx context.Context) error {
+	select {
+	case <-t.drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	shutdownErr := &ShutdownError{Handlers: t.handlers, Err: ctx.Err()}
+	for conn := range t.conns {
+		shutdownErr.Closed = append(shutdownErr.Closed, conn.RemoteAddr())
+		conn.Close()
+	}
+	return shutdownErr
+}
@@ -1,22 +1,90 @@
 package networktools
 
-import "net"
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Request types from ReservedTypeStart upwards are reserved for control messages sent by the library itself.
+// GenerateRequest and Router.Handle refuse them, so an application's own request types can never be mistaken for one.
+const ReservedTypeStart uint32 = 0xFFFFFF00
+
+// The library's control messages.
+const (
+	RequestPing  = ReservedTypeStart + iota // Answered by every Router with an empty RequestPing, to check a server is up
+	RequestAck                              // Acknowledges a request sent by a ReliableUDPSender
+	RequestError                            // Sent in place of the usual reply when a request couldn't be handled, see RemoteError
+)
+
+// ErrReservedType is returned when an application tries to generate a request with one of the reserved types, see ReservedTypeStart.
+var ErrReservedType = errors.New("request type is reserved for the library")
+
+// IsReservedType reports whether the request type is one of the library's control messages.
+func IsReservedType(reqType uint32) bool {
+	return reqType >= ReservedTypeStart
+}
 
 type Request_Type struct {
-	Type          uint8
+	Type          uint32
 	PayloadLength uint64
-	Payload       []byte // Raw data, can be interpreted based on the request type
+	Payload       []byte            // Raw data, can be interpreted based on the request type
+	CorrelationID uint64            // Set when the request came from a Client, replies must carry the same ID (see CorrelateReply)
+	Sequence      uint64            // Set when the request was sent by a ReliableUDPSender, requests from the same sender arrive in sequence order
+	Headers       map[string]string // Metadata set by the sender, see WithHeader, nil if there is none
+
+	stream uint64 // Which ReliableUDPSender the sequence number belongs to
+	ack    uint64 // The sequence number being acknowledged, when the datagram is an acknowledgement
+	base   uint64 // The lowest sequence number the sender is still waiting to have acknowledged
+
+	messageID     uint64 // Which fragmented request the datagram is part of, see FragmentRequest
+	fragmentIndex uint32
+	fragmentCount uint32 // 0 when the datagram is a whole request
 }
 
 // The key distinction between the network data types is the fact that UDP is connectionless
 type UDPNetworkData struct {
 	Request Request_Type
 	Addr    net.Addr
+
+	conn *net.UDPConn // The listener's socket the request arrived on
+	keys *SealKeys    // The listener's keys, replies are sealed with them too
+}
+
+// Reply sends data back to whoever sent the request, from the same socket the request arrived on.
+// Replying from the listener's own address matters for clients behind NAT, which only let replies through from the address they contacted.
+// The reply is tagged with the request's correlation ID, see CorrelateReply, and split into fragments if it is too big for one datagram.
+//
+// Example:
+//
+//	data := <-request_channel
+//	reply, _ := networktools.GenerateRequest(camera, RequestSuccessful)
+//	if err := data.Reply(reply); err != nil {
+//		fmt.Println("Error replying:", err)
+//	}
+func (d *UDPNetworkData) Reply(data []byte) error {
+	if d.conn == nil {
+		return fmt.Errorf("request did not come from a UDP listener")
+	}
+	return writeFragmented(CorrelateReply(data, d.Request), d.writeDatagram)
+}
+
+// writeDatagram sends a single datagram to whoever sent the request, sealed if the listener seals its datagrams.
+func (d *UDPNetworkData) writeDatagram(datagram []byte) error {
+	return sealedWrite(d.keys, sealReply, func(datagram []byte) error {
+		_, err := d.conn.WriteTo(datagram, d.Addr)
+		return err
+	})(datagram)
 }
 
 type TCPNetworkData struct {
 	Request Request_Type
 	Conn    net.Conn
+	Peer    *x509.Certificate // The client's verified certificate, only set on a TLS listener that verifies client certificates
+	Hello   Hello             // What the client announced in its handshake, with Features narrowed to those both ends support. Empty without a handshake
 }
 
 func (d *TCPNetworkData) Get_Addr() net.Addr {
@@ -27,13 +95,49 @@ func (d *TCPNetworkData) Get_Addr() net.Addr {
 type TCPListener struct {
 	StopCh   chan struct{}
 	Listener net.Listener
+
+	stopOnce sync.Once
+	tracker  *tracker
+	stats    *listenerStats
+}
+
+// Addr returns the address the listener is bound to.
+// It is how you find out which port was picked when the listener was created with port 0.
+//
+// Example:
+//
+//	request_channel, listener, err := Create_TCP_Listener(0)
+//	fmt.Println("Listening on", listener.Addr())
+func (l *TCPListener) Addr() net.Addr {
+	return l.Listener.Addr()
 }
 
 // Method to stop the listener
+// It stops accepting connections and tells the open ones to finish up, without waiting for them. Use Shutdown to wait.
 func (l *TCPListener) Stop() {
-	close(l.StopCh)
-	if l.Listener != nil {
-		l.Listener.Close()
+	l.stopOnce.Do(func() {
+		close(l.StopCh)
+		// The tracker has to know the listener is stopping before the socket is closed, so the accept loop doesn't take the close for a failure.
+		l.tracker.stop()
+		if l.Listener != nil {
+			l.Listener.Close()
+		}
+	})
+}
 
-	}
+// Shutdown stops the listener and waits for it to finish what it was doing.
+// Open connections are closed once the request they are reading, and its handler if the listener has a router, is done. The request channel is closed once nothing can send on it any more.
+// If the context ends first the remaining connections are closed, the handlers' contexts are cancelled and a *ShutdownError says what was cut off.
+// A listener with a request channel can't tell when you've replied, so reply to requests you've taken from the channel before calling Shutdown.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	if err := listener.Shutdown(ctx); err != nil {
+//		fmt.Println("Listener did not shut down cleanly:", err)
+//	}
+func (l *TCPListener) Shutdown(ctx context.Context) error {
+	l.Stop()
+	return l.tracker.wait(ctx)
 }
@@ -0,0 +1,59 @@
+package networktools
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ListenerStats counts what a listener has received since it was created.
+type ListenerStats struct {
+	Requests         uint64 // Requests received and handed on to the channel or router
+	Errors           uint64 // Problems the listener ran into, each of which is also passed to the function given to WithErrorHandler
+	ChecksumFailures uint64 // Requests dropped because their payload didn't match its checksum, also counted in Errors
+}
+
+// listenerStats is shared by a listener and the copies of its configuration, and updated atomically.
+type listenerStats struct {
+	requests         uint64
+	errors           uint64
+	checksumFailures uint64
+}
+
+func (s *listenerStats) received() {
+	if s != nil {
+		atomic.AddUint64(&s.requests, 1)
+	}
+}
+
+func (s *listenerStats) failed(err error) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.errors, 1)
+	if errors.Is(err, ErrChecksumMismatch) {
+		atomic.AddUint64(&s.checksumFailures, 1)
+	}
+}
+
+func (s *listenerStats) snapshot() ListenerStats {
+	return ListenerStats{
+		Requests:         atomic.LoadUint64(&s.requests),
+		Errors:           atomic.LoadUint64(&s.errors),
+		ChecksumFailures: atomic.LoadUint64(&s.checksumFailures),
+	}
+}
+
+// Stats returns what the listener has counted so far.
+//
+// Example:
+//
+//	stats := listener.Stats()
+//	fmt.Println("Dropped", stats.ChecksumFailures, "corrupted requests out of", stats.Requests+stats.ChecksumFailures)
+func (l *UDPListener) Stats() ListenerStats {
+	return l.stats.snapshot()
+}
+
+// Stats returns what the listener has counted so far, see UDPListener.Stats.
+func (l *TCPListener) Stats() ListenerStats {
+	return l.stats.snapshot()
+}
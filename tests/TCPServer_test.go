@@ -42,11 +42,14 @@ func DeserializeBasic(data []byte) (basic, error) {
 }
 
 func TestTransmission(t *testing.T) {
-	port := uint16(5050)
-	requestChannel, _ := networktool.Create_TCP_Listener(port)
+	requestChannel, listener, err := networktool.Create_TCP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
 
 	// Start data transmission in a goroutine
-	go transmit(port)
+	go transmit(listener.Addr().String())
 
 	// Set a timeout for the test
 	timeout := time.After(2 * time.Second)
@@ -91,9 +94,7 @@ func TestTransmission(t *testing.T) {
 	}
 }
 
-func transmit(port uint16) {
-	time.Sleep(40 * time.Millisecond)
-
+func transmit(ip_address string) {
 	test := "tested"
 
 	test_data := &basic{
@@ -102,7 +103,6 @@ func transmit(port uint16) {
 
 	req, err := networktool.GenerateRequest(test_data.ToProto(), 1)
 	fmt.Println(err)
-	ip_address := fmt.Sprintf("127.0.0.1:%d", port)
 	networktool.Handle_Single_TCP_Exchange(ip_address, req, 1024)
 
 }
@@ -1,9 +1,8 @@
 #!/bin/bash
 
-echo "Grabbing latest version from the git"
-go get -u github.com/diarmuidmalanaphy/networktools@latest
+echo "Testing against the local tree, go.mod replaces networktools with ../"
+go vet .
 
 echo "Running tests"
 
 go test . -v
-
@@ -0,0 +1,88 @@
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+// corrupt flips a bit in the payload of a serialised request, leaving it valid protobuf.
+func corrupt(t *testing.T, req []byte, payload []byte) []byte {
+	t.Helper()
+	i := bytes.Index(req, payload)
+	if i < 0 {
+		t.Fatalf("Payload not found in the request")
+	}
+	corrupted := append([]byte(nil), req...)
+	corrupted[i] ^= 0x01
+	return corrupted
+}
+
+func TestChecksum(t *testing.T) {
+	payload := []byte("camera snapshot")
+	req, _ := networktool.GenerateRawRequest(payload, 5)
+
+	if _, err := networktool.DeserialiseRequest(req); err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	if _, err := networktool.DeserialiseRequest(corrupt(t, req, payload)); !errors.Is(err, networktool.ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// Requests from senders that don't checksum are still accepted.
+	unchecked, _ := proto.Marshal(&pb.Request{Type: 5, PayloadSize: uint64(len(payload)), Payload: payload})
+	decoded, err := networktool.DeserialiseRequest(unchecked)
+	if err != nil || !bytes.Equal(decoded.Payload, payload) {
+		t.Fatalf("Expected the unchecked request to be accepted, got %q (%v)", decoded.Payload, err)
+	}
+}
+
+func TestChecksumListenerStats(t *testing.T) {
+	errCh := make(chan error, 4)
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0, networktool.WithErrorHandler(func(err error) {
+		errCh <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	payload := []byte("camera snapshot")
+	req, _ := networktool.GenerateRawRequest(payload, 5)
+	if err := networktool.SendUDP(addr, corrupt(t, req, payload)); err != nil {
+		t.Fatalf("SendUDP error: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, networktool.ErrChecksumMismatch) {
+			t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The corrupted request was never reported")
+	}
+
+	if err := networktool.SendUDP(addr, req); err != nil {
+		t.Fatalf("SendUDP error: %v", err)
+	}
+	select {
+	case data := <-requestChannel:
+		if !bytes.Equal(data.Request.Payload, payload) {
+			t.Fatalf("Unexpected payload %q", data.Request.Payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The request never arrived")
+	}
+
+	stats := listener.Stats()
+	if stats.Requests != 1 || stats.Errors != 1 || stats.ChecksumFailures != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,139 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestClientOutOfOrderReplies(t *testing.T) {
+	router := networktool.NewRouter()
+	networktool.Handle(router, 1, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		// Earlier requests take longer, so the replies come back in the opposite order.
+		delay := time.Duration(10-int(req.Name[0]-'0')) * 10 * time.Millisecond
+		time.Sleep(delay)
+		return req, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	client, err := networktool.Dial_Client(addr)
+	if err != nil {
+		t.Fatalf("Dial_Client error: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("%d", i)
+			req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte(name)}, 1)
+			reply, err := client.Call(ctx, req)
+			if err != nil {
+				t.Errorf("Call %d error: %v", i, err)
+				return
+			}
+			var b BasicProto
+			if err := networktool.DeserialiseData(&b, reply.Payload); err != nil {
+				t.Errorf("DeserialiseData error: %v", err)
+				return
+			}
+			if string(b.Name) != name {
+				t.Errorf("Call %s got the reply for %s", name, b.Name)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	client.Close()
+	req, _ := networktool.GenerateRequest(nil, 1)
+	if _, err := client.Call(ctx, req); err == nil {
+		t.Error("Expected an error calling a closed client")
+	}
+}
+
+// Replies are written while the listener waits for the connection's next request, and must not undo the idle timeout it set.
+func TestClientIdleTimeout(t *testing.T) {
+	router := networktool.NewRouter()
+	networktool.Handle(router, 1, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		return req, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router, networktool.WithIdleTimeout(300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+
+	client, err := networktool.Dial_Client(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial_Client error: %v", err)
+	}
+	defer client.Close()
+
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("idle")}, 1)
+	if _, err := client.Call(context.Background(), req); err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+
+	time.Sleep(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, req); !errors.Is(err, networktool.ErrClientClosed) {
+		t.Fatalf("Expected the idle connection to have been closed, got %v", err)
+	}
+}
+
+func TestClientCallStalledPeer(t *testing.T) {
+	// A server that accepts the connection but never reads from it, so the request can't be sent in full.
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(3 * time.Second)
+		}
+	}()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := networktool.Dial_Client_Context(cancelled, server.Addr().String()); err == nil {
+		t.Fatal("Expected dialing with a cancelled context to fail")
+	}
+
+	client, err := networktool.Dial_Client_Context(context.Background(), server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial_Client_Context error: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	req, _ := networktool.GenerateRawRequest(make([]byte, 32<<20), 1)
+	start := time.Now()
+	if _, err := client.Call(ctx, req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the call to time out sending, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Call took %s to give up", elapsed)
+	}
+}
@@ -0,0 +1,139 @@
+package testing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+// zlibCompressor is a custom compressor that counts how often it compresses, to check registered compressors are used.
+type zlibCompressor struct {
+	compressed *int32
+}
+
+func (zlibCompressor) Name() string { return "zlib" }
+
+func (c zlibCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	atomic.AddInt32(c.compressed, 1)
+	return zlib.NewWriter(w), nil
+}
+
+func (zlibCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("camera snapshot "), 1000)
+	plain, _ := networktool.GenerateRawRequest(payload, 5)
+
+	for _, name := range []string{networktool.CompressionGzip, networktool.CompressionFlate} {
+		req, err := networktool.GenerateRawRequest(payload, 5, networktool.WithCompression(name))
+		if err != nil {
+			t.Fatalf("%s: GenerateRawRequest error: %v", name, err)
+		}
+		if len(req) >= len(plain)/4 {
+			t.Fatalf("%s: expected the request to shrink from %d bytes, got %d", name, len(plain), len(req))
+		}
+		decoded, err := networktool.DeserialiseRequest(req)
+		if err != nil {
+			t.Fatalf("%s: DeserialiseRequest error: %v", name, err)
+		}
+		if !bytes.Equal(decoded.Payload, payload) || decoded.PayloadLength != uint64(len(payload)) {
+			t.Fatalf("%s: payload changed by compression", name)
+		}
+	}
+
+	// Payloads below the threshold are left alone.
+	small, _ := networktool.GenerateRawRequest([]byte("tiny"), 5, networktool.WithCompression(networktool.CompressionGzip))
+	uncompressed, _ := networktool.GenerateRawRequest([]byte("tiny"), 5)
+	if !bytes.Equal(small, uncompressed) {
+		t.Fatalf("Expected a small payload to be sent uncompressed")
+	}
+}
+
+func TestCompressionUnknown(t *testing.T) {
+	_, err := networktool.GenerateRawRequest(bytes.Repeat([]byte{1}, 2048), 5, networktool.WithCompression("missing"))
+	if !errors.Is(err, networktool.ErrUnknownCompression) {
+		t.Fatalf("Expected ErrUnknownCompression, got %v", err)
+	}
+}
+
+func TestCustomCompressorThroughRouter(t *testing.T) {
+	var compressed int32
+	networktool.RegisterCompressor(zlibCompressor{compressed: &compressed})
+
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		return networktool.GenerateRawRequest(req.Payload, 2, networktool.WithCompression("zlib"))
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+
+	payload := bytes.Repeat([]byte("state "), 5000)
+	req, _ := networktool.GenerateRawRequest(payload, 1, networktool.WithCompression("zlib"))
+	data, err := networktool.Handle_Single_TCP_Exchange(listener.Addr().String(), req, 1024)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	reply, err := networktool.DeserialiseRequest(data)
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	if reply.Type != 2 || !bytes.Equal(reply.Payload, payload) {
+		t.Fatalf("Unexpected reply of type %d with %d bytes", reply.Type, len(reply.Payload))
+	}
+	if n := atomic.LoadInt32(&compressed); n != 2 {
+		t.Fatalf("Expected the request and reply to be compressed, compressed %d times", n)
+	}
+}
+
+// A small compressed request can't get past the listener's size limit by decompressing to something much larger.
+func TestCompressionRespectsMaxMessageSize(t *testing.T) {
+	errCh := make(chan error, 1)
+	handled := make(chan struct{}, 1)
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		handled <- struct{}{}
+		return nil, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router,
+		networktool.WithMaxMessageSize(64<<10),
+		networktool.WithErrorHandler(func(err error) { errCh <- err }))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+
+	req, _ := networktool.GenerateRawRequest(make([]byte, 16<<20), 1, networktool.WithCompression(networktool.CompressionGzip))
+	if len(req) > 64<<10 {
+		t.Fatalf("Expected the compressed request to fit the limit, got %d bytes", len(req))
+	}
+	conn, err := networktool.SendInitialTCP(listener.Addr().String(), req)
+	if err != nil {
+		t.Fatalf("SendInitialTCP error: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-errCh:
+		var listenerErr *networktool.ListenerError
+		if !errors.As(err, &listenerErr) || listenerErr.Op != "deserialise" {
+			t.Fatalf("Expected a deserialise error, got %v", err)
+		}
+	case <-handled:
+		t.Fatal("The oversized payload reached the handler")
+	case <-time.After(2 * time.Second):
+		t.Fatal("The oversized payload was never rejected")
+	}
+}
@@ -0,0 +1,195 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestServerOptions(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_TCP_Listener(0,
+		networktool.WithHost("127.0.0.1"),
+		networktool.WithIPVersion(networktool.IPv4),
+		networktool.WithAnnounceIPs(false),
+		networktool.WithChannelBuffer(4),
+		networktool.WithIdleTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	if cap(requestChannel) != 4 {
+		t.Fatalf("Expected a channel buffer of 4, got %d", cap(requestChannel))
+	}
+
+	conn, err := net.Dial("tcp4", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	// The buffered channel accepts the request without anyone reading it.
+	req, _ := networktool.GenerateRequest(nil, 1)
+	if err := networktool.WriteFrame(conn, req); err != nil {
+		t.Fatalf("WriteFrame error: %v", err)
+	}
+
+	// After the idle timeout the server hangs up.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected the idle connection to be closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("Idle connection was not closed by the server")
+	}
+
+	if len(requestChannel) != 1 {
+		t.Fatalf("Expected 1 buffered request, got %d", len(requestChannel))
+	}
+}
+
+func TestPublicIPProviders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "203.0.113.7")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ip, err := networktool.HTTPPublicIPProvider{URL: server.URL}.PublicIP(ctx)
+	if err != nil || ip != "203.0.113.7" {
+		t.Fatalf("Expected 203.0.113.7 from the HTTP provider, got %q (%v)", ip, err)
+	}
+
+	ip, err = networktool.StaticPublicIP("198.51.100.1").PublicIP(ctx)
+	if err != nil || ip != "198.51.100.1" {
+		t.Fatalf("Expected 198.51.100.1 from the static provider, got %q (%v)", ip, err)
+	}
+}
+
+type recordingLogger struct {
+	messages chan string
+}
+
+func (l recordingLogger) Debug(msg string, args ...any) {}
+func (l recordingLogger) Info(msg string, args ...any)  {}
+func (l recordingLogger) Warn(msg string, args ...any)  { l.messages <- msg }
+func (l recordingLogger) Error(msg string, args ...any) { l.messages <- msg }
+
+func TestListenerLogger(t *testing.T) {
+	logger := recordingLogger{messages: make(chan string, 10)}
+	_, listener, err := networktool.Create_TCP_Listener(0, networktool.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	networktool.WriteFrame(conn, []byte{0xff, 0xff, 0xff})
+
+	select {
+	case msg := <-logger.messages:
+		if msg != "Error deserialising request" {
+			t.Fatalf("Unexpected log message %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the listener to log the bad request")
+	}
+}
+
+func TestListenerErrors(t *testing.T) {
+	errs := make(chan error, 10)
+	_, listener, err := networktool.Create_TCP_Listener(0, networktool.WithErrorHandler(func(err error) {
+		errs <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	// The port is taken, so the second listener reports it straight away.
+	if _, _, err := networktool.Create_TCP_Listener(uint16(listener.Addr().(*net.TCPAddr).Port)); err == nil {
+		t.Fatal("Expected an error binding a port that is already in use")
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	networktool.WriteFrame(conn, []byte{0xff, 0xff, 0xff})
+
+	select {
+	case err := <-errs:
+		listenerErr, ok := err.(*networktool.ListenerError)
+		if !ok || listenerErr.Op != "deserialise" || listenerErr.Remote == nil {
+			t.Fatalf("Unexpected listener error %#v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the error handler")
+	}
+}
+
+// A UDP request of the largest size accepted has to fit in the memory set aside for putting fragments back together.
+func TestDefaultFragmentMemoryHoldsLargestRequest(t *testing.T) {
+	cfg := networktool.DefaultServerConfig()
+	if cfg.MaxFragmentMemory != 0 && cfg.MaxFragmentMemory < int(cfg.MaxMessageSize) {
+		t.Fatalf("Fragment memory of %d bytes can't hold a request of %d bytes", cfg.MaxFragmentMemory, cfg.MaxMessageSize)
+	}
+}
+
+// A max message size of 0 means no limit, whichever way the request arrives.
+func TestMaxMessageSizeZeroIsUnlimited(t *testing.T) {
+	udpChannel, udpListener, err := networktool.Create_UDP_Listener(0, networktool.WithMaxMessageSize(0))
+	if err != nil {
+		t.Fatalf("Error creating UDP listener: %v", err)
+	}
+	defer udpListener.Stop()
+	udpAddr := fmt.Sprintf("127.0.0.1:%d", udpListener.Addr().(*net.UDPAddr).Port)
+
+	tcpChannel, tcpListener, err := networktool.Create_TCP_Listener(0, networktool.WithMaxMessageSize(0))
+	if err != nil {
+		t.Fatalf("Error creating TCP listener: %v", err)
+	}
+	defer tcpListener.Stop()
+
+	small, _ := networktool.GenerateRawRequest([]byte("status"), 1)
+	fragmented, _ := networktool.GenerateRawRequest(make([]byte, 20000), 2)
+	compressed, _ := networktool.GenerateRawRequest(make([]byte, 20000), 3, networktool.WithCompression(networktool.CompressionGzip))
+
+	for _, req := range [][]byte{small, fragmented, compressed} {
+		if err := networktool.SendUDP(udpAddr, req); err != nil {
+			t.Fatalf("SendUDP error: %v", err)
+		}
+		select {
+		case <-udpChannel:
+		case <-time.After(2 * time.Second):
+			t.Fatal("A UDP request was dropped by a listener with no size limit")
+		}
+
+		conn, err := networktool.SendInitialTCP(tcpListener.Addr().String(), req)
+		if err != nil {
+			t.Fatalf("SendInitialTCP error: %v", err)
+		}
+		select {
+		case <-tcpChannel:
+		case <-time.After(2 * time.Second):
+			t.Fatal("A TCP request was dropped by a listener with no size limit")
+		}
+		conn.Close()
+	}
+}
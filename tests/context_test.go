@@ -0,0 +1,43 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestExchangeContextCancelled(t *testing.T) {
+	// Nobody reads from the request channel, so the exchange never gets a reply.
+	_, listener, err := networktool.Create_TCP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	req, _ := networktool.GenerateRequest(nil, 1)
+	start := time.Now()
+	_, err = networktool.Handle_Single_TCP_Exchange_Context(ctx, addr, req, 1024)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Exchange took %s to notice the cancellation", time.Since(start))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = networktool.Handle_Single_TCP_Exchange_Context(ctx, addr, req, 1024)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
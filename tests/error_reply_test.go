@@ -0,0 +1,105 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func newFailingRouter(t *testing.T) *networktool.Router {
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		return nil, fmt.Errorf("camera offline")
+	})
+	router.Handle(2, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		details, err := anypb.New(&BasicProto{Name: []byte("camera-7")})
+		if err != nil {
+			t.Errorf("anypb.New error: %v", err)
+		}
+		return nil, fmt.Errorf("moving camera: %w", &networktool.RemoteError{Code: 404, Message: "no such camera", Details: details})
+	})
+	return router
+}
+
+func expectRemoteError(t *testing.T, err error, code uint32, message string) *networktool.RemoteError {
+	t.Helper()
+	var remoteErr *networktool.RemoteError
+	if !errors.As(err, &remoteErr) {
+		t.Fatalf("Expected a RemoteError, got %v", err)
+	}
+	if remoteErr.Code != code || remoteErr.Message != message {
+		t.Fatalf("Expected error %d %q, got %d %q", code, message, remoteErr.Code, remoteErr.Message)
+	}
+	return remoteErr
+}
+
+func TestErrorReplyTCP(t *testing.T) {
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, newFailingRouter(t))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	req, _ := networktool.GenerateRequest(nil, 1)
+	_, err = networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	expectRemoteError(t, err, networktool.CodeHandlerFailed, "camera offline")
+
+	req, _ = networktool.GenerateRequest(nil, 50)
+	_, err = networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	expectRemoteError(t, err, networktool.CodeNoHandler, "no handler registered for request type 50")
+
+	client, err := networktool.Dial_Client(addr)
+	if err != nil {
+		t.Fatalf("Dial_Client error: %v", err)
+	}
+	defer client.Close()
+
+	req, _ = networktool.GenerateRequest(nil, 2)
+	_, err = client.Call(context.Background(), req)
+	remoteErr := expectRemoteError(t, err, 404, "no such camera")
+	var details BasicProto
+	if err := remoteErr.Details.UnmarshalTo(&details); err != nil || string(details.Name) != "camera-7" {
+		t.Fatalf("Expected camera-7 in the details, got %q (%v)", details.Name, err)
+	}
+}
+
+func TestErrorReplyUDP(t *testing.T) {
+	listener, err := networktool.Create_UDP_Listener_With_Router(0, newFailingRouter(t))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	req, _ := networktool.GenerateRequest(nil, 1)
+	_, err = networktool.Handle_Single_UDP_Exchange(addr, req)
+	expectRemoteError(t, err, networktool.CodeHandlerFailed, "camera offline")
+
+	client, err := networktool.Dial_UDP_Client(addr, networktool.DefaultRetryPolicy)
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer client.Close()
+
+	req, _ = networktool.GenerateRequest(nil, 2)
+	_, err = client.Call(context.Background(), req)
+	expectRemoteError(t, err, 404, "no such camera")
+
+	// Unknown request types are dropped over UDP rather than answered.
+	quiet, err := networktool.Dial_UDP_Client(addr, networktool.RetryPolicy{Attempts: 2, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer quiet.Close()
+	req, _ = networktool.GenerateRequest(nil, 50)
+	if _, err := quiet.Call(context.Background(), req); !errors.Is(err, networktool.ErrNoReply) {
+		t.Fatalf("Expected no reply to an unknown request type, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUDPLargeExchange(t *testing.T) {
+	listener, err := networktool.Create_UDP_Listener_With_Router(0, newEchoRouter())
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	// Far bigger than a datagram, so both the request and the echoed reply travel in fragments.
+	name := bytes.Repeat([]byte("fragment"), 25000)
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: name}, 1)
+	data, err := networktool.Handle_Single_UDP_Exchange(addr, req)
+	if err != nil {
+		t.Fatalf("Handle_Single_UDP_Exchange error: %v", err)
+	}
+
+	reply, err := networktool.DeserialiseRequest(data)
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	var b BasicProto
+	if err := networktool.DeserialiseData(&b, reply.Payload); err != nil {
+		t.Fatalf("DeserialiseData error: %v", err)
+	}
+	if reply.Type != 2 || !bytes.Equal(b.Name, name) {
+		t.Fatalf("Reply of type %d with %d bytes did not match the request", reply.Type, len(b.Name))
+	}
+}
+
+func TestFragmentRequest(t *testing.T) {
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: bytes.Repeat([]byte("x"), 5000)}, 1)
+	fragments, err := networktool.FragmentRequest(req, 1000)
+	if err != nil {
+		t.Fatalf("FragmentRequest error: %v", err)
+	}
+	if len(fragments) < 6 {
+		t.Fatalf("Expected at least 6 fragments, got %d", len(fragments))
+	}
+	for i, fragment := range fragments {
+		if len(fragment) > 1000 {
+			t.Fatalf("Fragment %d is %d bytes", i, len(fragment))
+		}
+	}
+
+	small, _ := networktool.GenerateRequest(nil, 1)
+	if fragments, _ := networktool.FragmentRequest(small, 1000); len(fragments) != 1 || !bytes.Equal(fragments[0], small) {
+		t.Fatal("A request that fits in one datagram should be left alone")
+	}
+	if _, err := networktool.FragmentRequest(req, 10); err == nil {
+		t.Fatal("Expected an error for a datagram size too small to hold a fragment")
+	}
+}
+
+func TestIncompleteFragmentsTimeOut(t *testing.T) {
+	errs := make(chan error, 1)
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0,
+		networktool.WithFragmentTimeout(50*time.Millisecond),
+		networktool.WithPollInterval(20*time.Millisecond),
+		networktool.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	sender, err := networktool.NewUDPSender("")
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	// Only the first fragment is sent, the rest are "lost".
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: bytes.Repeat([]byte("x"), 5000)}, 1)
+	fragments, _ := networktool.FragmentRequest(req, 1000)
+	if err := sender.Send(addr, fragments[0]); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		var listenerErr *networktool.ListenerError
+		if !errors.As(err, &listenerErr) || listenerErr.Op != "read" {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The incomplete request was never dropped")
+	}
+	select {
+	case data := <-requestChannel:
+		t.Fatalf("An incomplete request was handed on: %+v", data.Request)
+	default:
+	}
+}
+
+// fragmentsOf splits a request into two fragments by hand, so a test can choose which of them to send.
+func fragmentsOf(t *testing.T, messageID uint64, req []byte) [2][]byte {
+	t.Helper()
+	var fragments [2][]byte
+	for i, chunk := range [][]byte{req[:len(req)/2], req[len(req)/2:]} {
+		fragment, err := proto.Marshal(&pb.Request{MessageId: messageID, FragmentIndex: uint32(i), FragmentCount: 2, Payload: chunk})
+		if err != nil {
+			t.Fatalf("Marshal error: %v", err)
+		}
+		fragments[i] = fragment
+	}
+	return fragments
+}
+
+// Fragments cost more to hold than the bytes they carry, which has to count towards the memory limit so floods of tiny fragments can't get round it.
+func TestFragmentMemoryCountsOverhead(t *testing.T) {
+	errs := make(chan error, 1024)
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0,
+		networktool.WithMaxFragmentMemory(1024),
+		networktool.WithErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	sender, err := networktool.NewUDPSender("")
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+	expectRejected := func(fragment []byte) {
+		t.Helper()
+		if err := sender.Send(addr, fragment); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		select {
+		case <-errs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("The fragment was accepted")
+		}
+	}
+
+	// Empty fragments and fragment counts no request within the size limit could need are turned away outright.
+	empty, _ := proto.Marshal(&pb.Request{MessageId: 1, FragmentIndex: 0, FragmentCount: 1000})
+	expectRejected(empty)
+	huge, _ := proto.Marshal(&pb.Request{MessageId: 2, FragmentIndex: 1 << 30, FragmentCount: 1<<30 + 1, Payload: []byte{1}})
+	expectRejected(huge)
+
+	// Two half requests hold only 600 bytes of payload, but with their overhead they don't both fit in 1024 bytes, so the first is dropped.
+	req, _ := networktool.GenerateRawRequest(bytes.Repeat([]byte("x"), 590), 1)
+	first, second := fragmentsOf(t, 10, req), fragmentsOf(t, 11, req)
+	for _, fragment := range [][]byte{first[0], second[0], second[1], first[1]} {
+		if err := sender.Send(addr, fragment); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+	select {
+	case <-requestChannel:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The second request was never put together")
+	}
+	select {
+	case <-requestChannel:
+		t.Fatal("Both requests were held, the overhead wasn't counted")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// One sender can't have more than a fixed number of requests waiting on fragments, its oldest is dropped to make way.
+func TestFragmentPartialsCappedPerSender(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0, networktool.WithChannelBuffer(1))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	sender, err := networktool.NewUDPSender("")
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	req, _ := networktool.GenerateRawRequest(bytes.Repeat([]byte("x"), 590), 1)
+	oldest := fragmentsOf(t, 100, req)
+	sender.Send(addr, oldest[0])
+	for id := uint64(101); id < 200; id++ {
+		time.Sleep(time.Millisecond) // Keeps the order they started in clear
+		sender.Send(addr, fragmentsOf(t, id, req)[0])
+	}
+	sender.Send(addr, oldest[1])
+
+	select {
+	case <-requestChannel:
+		t.Fatal("The oldest request was still held after a hundred newer ones")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
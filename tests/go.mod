@@ -6,3 +6,5 @@ require (
 	github.com/DiarmuidMalanaphy/networktools v0.3.20
 	google.golang.org/protobuf v1.34.2
 )
+
+replace github.com/DiarmuidMalanaphy/networktools => ../
@@ -0,0 +1,85 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestHandshake(t *testing.T) {
+	errs := make(chan error, 4)
+	requestChannel, listener, err := networktool.Create_TCP_Listener(0,
+		networktool.WithHandshake(networktool.Hello{
+			AppID:    "cameras",
+			Features: []string{networktool.FeatureCompression, networktool.FeatureFraming},
+		}),
+		networktool.WithErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := listener.Addr().String()
+
+	go func() {
+		for data := range requestChannel {
+			reply, _ := networktool.GenerateRawRequest([]byte(data.Hello.AppID), 2)
+			networktool.SendTCPReply(data.Conn, networktool.CorrelateReply(reply, data.Request))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	client, server, err := networktool.Dial_Client_With_Handshake(ctx, addr, networktool.Hello{
+		AppID:    "cameras",
+		Features: []string{networktool.FeatureCompression, networktool.FeatureEncryption},
+	})
+	if err != nil {
+		t.Fatalf("Dial_Client_With_Handshake error: %v", err)
+	}
+	defer client.Close()
+	if !reflect.DeepEqual(server.Features, []string{networktool.FeatureCompression}) {
+		t.Fatalf("Expected to agree on compression only, got %v", server.Features)
+	}
+
+	req, _ := networktool.GenerateRequest(nil, 1)
+	reply, err := client.Call(ctx, req)
+	if err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+	if string(reply.Payload) != "cameras" {
+		t.Fatalf("The listener saw the client as %q", reply.Payload)
+	}
+
+	// A different application is refused, and told why.
+	_, _, err = networktool.Dial_Client_With_Handshake(ctx, addr, networktool.Hello{AppID: "doorbells"})
+	var handshakeErr *networktool.HandshakeError
+	if !errors.As(err, &handshakeErr) || !handshakeErr.Remote {
+		t.Fatalf("Expected the listener to refuse the handshake, got %v", err)
+	}
+
+	// So is a client that needs a feature the listener doesn't have.
+	_, _, err = networktool.Dial_Client_With_Handshake(ctx, addr, networktool.Hello{RequiredFeatures: []string{networktool.FeatureEncryption}})
+	if !errors.As(err, &handshakeErr) {
+		t.Fatalf("Expected a HandshakeError, got %v", err)
+	}
+
+	// A client that doesn't know about handshakes sends its request straight away.
+	networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			var listenerErr *networktool.ListenerError
+			if !errors.As(err, &listenerErr) || listenerErr.Op != "handshake" || !errors.As(err, &handshakeErr) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Only %d of 3 failed handshakes were reported", i)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestHeadersRoundTrip(t *testing.T) {
+	req, err := networktool.GenerateRawRequest([]byte("payload"), 7,
+		networktool.WithHeader("trace-id", "abc"),
+		networktool.WithHeaders(map[string]string{"tenant": "warehouse-3"}))
+	if err != nil {
+		t.Fatalf("GenerateRawRequest error: %v", err)
+	}
+	decoded, err := networktool.DeserialiseRequest(req)
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	if decoded.Headers["trace-id"] != "abc" || decoded.Headers["tenant"] != "warehouse-3" || len(decoded.Headers) != 2 {
+		t.Fatalf("Unexpected headers %v", decoded.Headers)
+	}
+	if string(decoded.Payload) != "payload" || decoded.Type != 7 {
+		t.Fatalf("Request changed by headers: %+v", decoded)
+	}
+
+	plain, _ := networktool.GenerateRawRequest([]byte("payload"), 7)
+	decoded, _ = networktool.DeserialiseRequest(plain)
+	if decoded.Headers != nil {
+		t.Fatalf("Expected no headers, got %v", decoded.Headers)
+	}
+}
+
+// A handler calling another service with its own context passes the incoming headers on,
+// while headers set on the outgoing request itself take precedence.
+func TestHeadersPropagateThroughRouter(t *testing.T) {
+	downstream := networktool.NewRouter()
+	downstream.Handle(2, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		headers := networktool.ContextHeaders(ctx)
+		return networktool.GenerateRawRequest([]byte(headers["trace-id"]+","+headers["hop"]), 3)
+	})
+	downstreamListener, err := networktool.Create_TCP_Listener_With_Router(0, downstream)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer downstreamListener.Stop()
+
+	client, err := networktool.Dial_Client(downstreamListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial_Client error: %v", err)
+	}
+	defer client.Close()
+
+	upstream := networktool.NewRouter()
+	upstream.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		call, err := networktool.GenerateRequest(nil, 2, networktool.WithHeader("hop", "second"))
+		if err != nil {
+			return nil, err
+		}
+		reply, err := client.Call(ctx, call)
+		if err != nil {
+			return nil, err
+		}
+		return networktool.GenerateRawRequest(reply.Payload, 4)
+	})
+	upstreamListener, err := networktool.Create_UDP_Listener_With_Router(0, upstream)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer upstreamListener.Stop()
+
+	udpClient, err := networktool.Dial_UDP_Client(fmt.Sprintf("127.0.0.1:%d", upstreamListener.Addr().(*net.UDPAddr).Port), networktool.DefaultRetryPolicy)
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer udpClient.Close()
+
+	ctx := networktool.ContextWithHeaders(context.Background(), map[string]string{"trace-id": "abc", "hop": "first"})
+	req, _ := networktool.GenerateRequest(nil, 1)
+	reply, err := udpClient.Call(ctx, req)
+	if err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+	if reply.Type != 4 || string(reply.Payload) != "abc,second" {
+		t.Fatalf("Expected abc,second in a type 4 reply, got %q (type %d)", reply.Payload, reply.Type)
+	}
+}
@@ -0,0 +1,231 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReliableReordersAndAcknowledges(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listener.Addr().(*net.UDPAddr).Port}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	// Send 3, 2 and 1 as if the network had reordered them, plus a repeat of 2 as if its acknowledgement had been lost.
+	for _, sequence := range []uint64{3, 2, 1, 2} {
+		req, _ := proto.Marshal(&pb.Request{Type: uint32(sequence), Sequence: sequence, Stream: 42})
+		if _, err := conn.WriteToUDP(req, target); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+
+	for want := uint32(1); want <= 3; want++ {
+		select {
+		case data := <-requestChannel:
+			if data.Request.Type != want {
+				t.Fatalf("Expected request %d, got %d", want, data.Request.Type)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", want)
+		}
+	}
+	select {
+	case data := <-requestChannel:
+		t.Fatalf("The repeated request was handed on again as %d", data.Request.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	acked := make(map[uint64]int)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 1024)
+	for i := 0; i < 4; i++ {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatalf("Read error after %d acknowledgements: %v", i, err)
+		}
+		var ack pb.Request
+		if err := proto.Unmarshal(buffer[:n], &ack); err != nil || ack.Stream != 42 {
+			t.Fatalf("Unexpected acknowledgement %v: %v", &ack, err)
+		}
+		acked[ack.Ack]++
+	}
+	if acked[1] != 1 || acked[2] != 2 || acked[3] != 1 {
+		t.Fatalf("Unexpected acknowledgements %v", acked)
+	}
+}
+
+func TestReliableSkipsMissingRequest(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0,
+		networktool.WithReliableGapTimeout(100*time.Millisecond), networktool.WithPollInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listener.Addr().(*net.UDPAddr).Port}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	// Request 1 never arrives, so request 2 is held back until the listener gives up on it.
+	req, _ := proto.Marshal(&pb.Request{Type: 2, Sequence: 2, Stream: 42})
+	conn.WriteToUDP(req, target)
+
+	select {
+	case data := <-requestChannel:
+		if data.Request.Sequence != 2 {
+			t.Fatalf("Expected request 2, got %d", data.Request.Sequence)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The held request was never handed on")
+	}
+}
+
+func TestReliableUDPSender(t *testing.T) {
+	// Sends wait for acknowledgements before the test reads anything, so the channel has to hold the requests meanwhile.
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0, networktool.WithChannelBuffer(8))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	sender, err := networktool.NewReliableUDPSender("", networktool.DefaultRetryPolicy, 1)
+	if err != nil {
+		t.Fatalf("NewReliableUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		req, _ := networktool.GenerateRequest(nil, 1)
+		if err := sender.Send(ctx, addr, req); err != nil {
+			t.Fatalf("Send %d error: %v", i, err)
+		}
+	}
+	telemetry, _ := networktool.GenerateRequest(nil, 2)
+	if err := sender.Send(ctx, addr, telemetry); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	for i := uint64(1); i <= 6; i++ {
+		select {
+		case data := <-requestChannel:
+			if i <= 5 && data.Request.Sequence != i {
+				t.Fatalf("Expected sequence %d, got %d", i, data.Request.Sequence)
+			}
+			if i == 6 && (data.Request.Type != 2 || data.Request.Sequence != 0) {
+				t.Fatalf("Expected an unsequenced request of type 2, got %+v", data.Request)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", i)
+		}
+	}
+
+	// Nothing acknowledges requests sent to a plain socket.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	quick, err := networktool.NewReliableUDPSender("", networktool.RetryPolicy{Attempts: 2, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewReliableUDPSender error: %v", err)
+	}
+	defer quick.Close()
+	req, _ := networktool.GenerateRequest(nil, 1)
+	if err := quick.Send(ctx, conn.LocalAddr().String(), req); !errors.Is(err, networktool.ErrNotAcknowledged) {
+		t.Fatalf("Expected ErrNotAcknowledged, got %v", err)
+	}
+}
+
+// A listener that restarts, or forgets a sender that went quiet, has to pick the sender's numbering up where it is rather than from 1.
+func TestReliableSenderSurvivesListenerRestart(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	port := uint16(listener.Addr().(*net.UDPAddr).Port)
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	go func() {
+		for range requestChannel {
+		}
+	}()
+
+	sender, err := networktool.NewReliableUDPSender("", networktool.DefaultRetryPolicy)
+	if err != nil {
+		t.Fatalf("NewReliableUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := networktool.GenerateRequest(nil, 1)
+	for i := 0; i < 300; i++ {
+		if err := sender.Send(ctx, addr, req); err != nil {
+			t.Fatalf("Send %d error: %v", i, err)
+		}
+	}
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	requestChannel, listener, err = networktool.Create_UDP_Listener(port, networktool.WithChannelBuffer(1))
+	if err != nil {
+		t.Fatalf("Error recreating listener: %v", err)
+	}
+	defer listener.Stop()
+
+	if err := sender.Send(ctx, addr, req); err != nil {
+		t.Fatalf("Send after the restart error: %v", err)
+	}
+	select {
+	case data := <-requestChannel:
+		if data.Request.Sequence != 301 {
+			t.Fatalf("Expected request 301, got %d", data.Request.Sequence)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The request sent after the restart never arrived")
+	}
+}
+
+// Only the request's type decides whether it is sent reliably, its payload isn't decoded to find out.
+func TestReliableUDPSenderReadsOnlyType(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	sender, err := networktool.NewReliableUDPSender("", networktool.RetryPolicy{Attempts: 2, Timeout: 10 * time.Millisecond}, 1)
+	if err != nil {
+		t.Fatalf("NewReliableUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	// The payload says it was compressed with a compressor nobody has, so it can't be deserialised here.
+	req, _ := proto.Marshal(&pb.Request{Type: 2, PayloadSize: 4096, Payload: []byte("zstd"), Compression: "zstd"})
+	if err := sender.Send(context.Background(), conn.LocalAddr().String(), req); err != nil {
+		t.Fatalf("Expected the request to be sent once without waiting for an acknowledgement, got %v", err)
+	}
+}
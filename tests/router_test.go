@@ -0,0 +1,219 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	pb "github.com/DiarmuidMalanaphy/networktools/standards"
+	"google.golang.org/protobuf/proto"
+)
+
+func newEchoRouter() *networktool.Router {
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		var b BasicProto
+		if err := networktool.DeserialiseData(&b, req.Payload); err != nil {
+			return nil, err
+		}
+		return networktool.GenerateRequest(&b, 2)
+	})
+	router.HandleFallback(func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		return networktool.GenerateRequest(nil, 99)
+	})
+	return router
+}
+
+func TestTCPRouter(t *testing.T) {
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, newEchoRouter())
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	req, _ := networktool.GenerateRequest((&basic{Name: stringToUsername("tested")}).ToProto(), 1)
+	data, err := networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	reply, err := networktool.DeserialiseRequest(data)
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	if reply.Type != 2 {
+		t.Fatalf("Expected reply type 2, got %d", reply.Type)
+	}
+	deserialized, err := DeserializeBasic(reply.Payload)
+	if err != nil || deserialized.to_string() != "tested" {
+		t.Fatalf("Expected tested, got %q (%v)", deserialized.to_string(), err)
+	}
+
+	req, _ = networktool.GenerateRequest(nil, 50)
+	data, err = networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	reply, _ = networktool.DeserialiseRequest(data)
+	if reply.Type != 99 {
+		t.Fatalf("Expected fallback reply type 99, got %d", reply.Type)
+	}
+}
+
+func TestUDPRouter(t *testing.T) {
+	listener, err := networktool.Create_UDP_Listener_With_Router(0, newEchoRouter())
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	req, _ := networktool.GenerateRequest((&basic{Name: stringToUsername("tested")}).ToProto(), 1)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 1024)
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	reply, err := networktool.DeserialiseRequest(buffer[:n])
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	if reply.Type != 2 {
+		t.Fatalf("Expected reply type 2, got %d", reply.Type)
+	}
+}
+
+func TestTypedHandler(t *testing.T) {
+	router := networktool.NewRouter()
+	networktool.Handle(router, 3, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		if networktool.PeerAddr(ctx) == nil {
+			return nil, fmt.Errorf("missing peer address")
+		}
+		return &BasicProto{Name: append(req.Name, '!')}, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	defer listener.Stop()
+
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("tested")}, 3)
+	data, err := networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	reply, _ := networktool.DeserialiseRequest(data)
+	var b BasicProto
+	if err := networktool.DeserialiseData(&b, reply.Payload); err != nil {
+		t.Fatalf("DeserialiseData error: %v", err)
+	}
+	if reply.Type != 3 || string(b.Name) != "tested!" {
+		t.Fatalf("Unexpected reply type %d with name %q", reply.Type, b.Name)
+	}
+
+	// A payload that isn't a valid BasicProto gets the decode failure reply.
+	req, _ = networktool.GenerateRawRequest([]byte{0xff, 0xff, 0xff}, 3)
+	_, err = networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	var remoteErr *networktool.RemoteError
+	if !errors.As(err, &remoteErr) || remoteErr.Code != networktool.CodeDecodeFailed {
+		t.Fatalf("Expected decode failure error, got %v", err)
+	}
+}
+
+func TestUDPReply(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	port := listener.Addr().(*net.UDPAddr).Port
+
+	go func() {
+		data := <-requestChannel
+		reply, _ := networktool.GenerateRequest(nil, 8)
+		data.Reply(reply)
+	}()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	req, _ := networktool.GenerateRequest(nil, 7)
+	if _, err := conn.WriteToUDP(req, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, 1024)
+	n, from, err := conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if from.Port != port {
+		t.Fatalf("Reply came from port %d instead of the listener's port %d", from.Port, port)
+	}
+	reply, _ := networktool.DeserialiseRequest(buffer[:n])
+	if reply.Type != 8 {
+		t.Fatalf("Expected reply type 8, got %d", reply.Type)
+	}
+}
+
+func TestWideRequestTypes(t *testing.T) {
+	router := networktool.NewRouter()
+	router.Handle(70000, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		return networktool.GenerateRequest(nil, req.Type+1)
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := listener.Addr().String()
+
+	req, _ := networktool.GenerateRequest(nil, 70000)
+	data, err := networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	if err != nil {
+		t.Fatalf("Exchange error: %v", err)
+	}
+	if reply, _ := networktool.DeserialiseRequest(data); reply.Type != 70001 {
+		t.Fatalf("Expected reply type 70001, got %d", reply.Type)
+	}
+
+	// Pings are answered by the router without a handler of their own.
+	ping, _ := proto.Marshal(&pb.Request{Type: networktool.RequestPing})
+	data, err = networktool.Handle_Single_TCP_Exchange(addr, ping, 1024)
+	if err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	if reply, _ := networktool.DeserialiseRequest(data); reply.Type != networktool.RequestPing {
+		t.Fatalf("Expected a ping back, got type %d", reply.Type)
+	}
+
+	if _, err := networktool.GenerateRequest(nil, networktool.RequestAck); !errors.Is(err, networktool.ErrReservedType) {
+		t.Fatalf("Expected ErrReservedType, got %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected registering a handler for a reserved type to panic")
+		}
+	}()
+	router.Handle(networktool.RequestError, nil)
+}
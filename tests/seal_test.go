@@ -0,0 +1,197 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestSealedExchange(t *testing.T) {
+	keys, err := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("NewSealKeys error: %v", err)
+	}
+	listener, err := networktool.Create_UDP_Listener_With_Router(0, newEchoRouter(), networktool.WithSealKeys(keys))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("sealed")}, 1)
+	data, err := networktool.Handle_Single_Sealed_UDP_Exchange(addr, req, keys)
+	if err != nil {
+		t.Fatalf("Handle_Single_Sealed_UDP_Exchange error: %v", err)
+	}
+	reply, _ := networktool.DeserialiseRequest(data)
+	if reply.Type != 2 {
+		t.Fatalf("Expected reply type 2, got %d", reply.Type)
+	}
+
+	// A client without the keys can't read the sealed reply, or even get the request through.
+	plain, err := networktool.Dial_UDP_Client(addr, networktool.RetryPolicy{Attempts: 2, Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer plain.Close()
+	if _, err := plain.Call(context.Background(), req); !errors.Is(err, networktool.ErrNoReply) {
+		t.Fatalf("Expected ErrNoReply, got %v", err)
+	}
+}
+
+func TestSealedListenerRejects(t *testing.T) {
+	keys, _ := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	otherKeys, _ := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{2}, 32)})
+
+	errs := make(chan error, 8)
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0, networktool.WithSealKeys(keys), networktool.WithChannelBuffer(8),
+		networktool.WithErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	sender, err := networktool.NewUDPSender("")
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	req, _ := networktool.GenerateRequest(nil, 7)
+	sealed, _ := keys.Seal(req)
+	wrongKey, _ := otherKeys.Seal(req)
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	// The genuine datagram goes through once, everything else including its replay is rejected.
+	for _, datagram := range [][]byte{req, wrongKey, tampered, sealed, sealed} {
+		if err := sender.Send(addr, datagram); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		select {
+		case err := <-errs:
+			var listenerErr *networktool.ListenerError
+			if !errors.As(err, &listenerErr) || listenerErr.Op != "unseal" || !errors.Is(err, networktool.ErrUnsealFailed) {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Only %d of 4 datagrams were rejected", i)
+		}
+	}
+	select {
+	case data := <-requestChannel:
+		if data.Request.Type != 7 {
+			t.Fatalf("Unexpected request %+v", data.Request)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The genuine datagram never arrived")
+	}
+	select {
+	case data := <-requestChannel:
+		t.Fatalf("A rejected datagram reached the channel: %+v", data.Request)
+	default:
+	}
+}
+
+func TestSealKeyRotation(t *testing.T) {
+	receiver, _ := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 16), 2: bytes.Repeat([]byte{2}, 16)})
+	sender, _ := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 16)})
+
+	old, _ := sender.Seal([]byte("old"))
+	if err := sender.AddKey(2, bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatalf("AddKey error: %v", err)
+	}
+	if err := sender.UseKey(2); err != nil {
+		t.Fatalf("UseKey error: %v", err)
+	}
+	current, _ := sender.Seal([]byte("new"))
+
+	if opened, err := receiver.Open(current); err != nil || string(opened) != "new" {
+		t.Fatalf("Open of the new key failed: %q %v", opened, err)
+	}
+	receiver.UseKey(2)
+	if err := receiver.RemoveKey(1); err != nil {
+		t.Fatalf("RemoveKey error: %v", err)
+	}
+	if _, err := receiver.Open(old); !errors.Is(err, networktool.ErrUnsealFailed) {
+		t.Fatalf("Expected the removed key to be rejected, got %v", err)
+	}
+	if err := sender.RemoveKey(2); err == nil {
+		t.Fatal("Expected removing the key in use to fail")
+	}
+}
+
+// A sealed reply sent back to the listener that sealed it is rejected rather than taken for a new request.
+func TestSealedReplyCantBeReflected(t *testing.T) {
+	keys, _ := networktool.NewSealKeys(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+
+	errs := make(chan error, 8)
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0, networktool.WithSealKeys(keys),
+		networktool.WithErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	target := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listener.Addr().(*net.UDPAddr).Port}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+
+	req, _ := networktool.GenerateRequest(nil, 7)
+	sealed, _ := keys.Seal(req)
+	if _, err := conn.WriteToUDP(sealed, target); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	select {
+	case data := <-requestChannel:
+		reply, _ := networktool.GenerateRequest(nil, 8)
+		if err := data.Reply(reply); err != nil {
+			t.Fatalf("Reply error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The request never arrived")
+	}
+
+	// Capture the sealed reply, as anyone on the path could.
+	buffer := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buffer)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	captured := append([]byte(nil), buffer[:n]...)
+	if _, err := conn.WriteToUDP(captured, target); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, networktool.ErrUnsealFailed) {
+			t.Fatalf("Expected ErrUnsealFailed, got %v", err)
+		}
+	case data := <-requestChannel:
+		t.Fatalf("The reflected reply was taken for a request: %+v", data.Request)
+	case <-time.After(2 * time.Second):
+		t.Fatal("The reflected reply was never rejected")
+	}
+
+	// The reply still opens as a reply.
+	if opened, err := keys.OpenReply(captured); err != nil {
+		t.Fatalf("OpenReply error: %v", err)
+	} else if reply, _ := networktool.DeserialiseRequest(opened); reply.Type != 8 {
+		t.Fatalf("Expected reply type 8, got %d", reply.Type)
+	}
+}
@@ -0,0 +1,210 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestShutdownDrainsHandlers(t *testing.T) {
+	started := make(chan struct{})
+	router := networktool.NewRouter()
+	networktool.Handle(router, 1, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return req, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+
+	replies := make(chan error, 1)
+	go func() {
+		req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("tested")}, 1)
+		_, err := networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+		replies <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if err := <-replies; err != nil {
+		t.Fatalf("The request in flight during shutdown failed: %v", err)
+	}
+}
+
+// Requests from a Client are handled on their own while the connection waits for the next one, and still get their reply.
+func TestShutdownDrainsClientCalls(t *testing.T) {
+	started := make(chan struct{})
+	router := networktool.NewRouter()
+	networktool.Handle(router, 1, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		return req, nil
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+
+	client, err := networktool.Dial_Client(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial_Client error: %v", err)
+	}
+	defer client.Close()
+
+	replies := make(chan error, 1)
+	go func() {
+		req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("tested")}, 1)
+		_, err := client.Call(context.Background(), req)
+		replies <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("Shutdown returned after %s, before the handler finished", elapsed)
+	}
+	if err := <-replies; err != nil {
+		t.Fatalf("The call in flight during shutdown failed: %v", err)
+	}
+}
+
+func TestShutdownForcesAfterDeadline(t *testing.T) {
+	started := make(chan struct{})
+	router := networktool.NewRouter()
+	networktool.Handle(router, 1, func(ctx context.Context, req *BasicProto) (proto.Message, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := listener.Addr().String()
+
+	go func() {
+		req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("tested")}, 1)
+		networktool.Handle_Single_TCP_Exchange(addr, req, 1024)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = listener.Shutdown(ctx)
+
+	var shutdownErr *networktool.ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatalf("Expected a ShutdownError, got %v", err)
+	}
+	if len(shutdownErr.Closed) != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Unexpected shutdown report: %v", err)
+	}
+}
+
+func TestShutdownClosesRequestChannel(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(5063)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+
+	select {
+	case _, ok := <-requestChannel:
+		if ok {
+			t.Fatal("Expected the request channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request channel was not closed")
+	}
+}
+
+// Closing the socket on a normal stop isn't a failure to accept.
+func TestStopReportsNoErrors(t *testing.T) {
+	errCh := make(chan error, 1)
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, networktool.NewRouter(), networktool.WithErrorHandler(func(err error) {
+		errCh <- err
+	}))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := listener.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown error: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("Unexpected error reported on stop: %v", err)
+	default:
+	}
+	if stats := listener.Stats(); stats.Errors != 0 {
+		t.Fatalf("Expected no errors counted, got %+v", stats)
+	}
+}
+
+// A connection that never finishes its handshake doesn't hold up shutdown, even without a read timeout.
+func TestShutdownDuringHandshake(t *testing.T) {
+	dir := writeTestPKI(t)
+	tlsConfig, err := networktool.NewServerTLSConfig(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig error: %v", err)
+	}
+
+	for name, opt := range map[string]networktool.ServerOption{
+		"hello": networktool.WithHandshake(networktool.Hello{AppID: "cameras"}),
+		"tls":   networktool.WithTLS(tlsConfig),
+	} {
+		t.Run(name, func(t *testing.T) {
+			errCh := make(chan error, 1)
+			listener, err := networktool.Create_TCP_Listener_With_Router(0, networktool.NewRouter(), opt,
+				networktool.WithReadTimeout(0),
+				networktool.WithErrorHandler(func(err error) { errCh <- err }))
+			if err != nil {
+				t.Fatalf("Error creating listener: %v", err)
+			}
+
+			// Connect but never start the handshake.
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Dial error: %v", err)
+			}
+			defer conn.Close()
+			time.Sleep(100 * time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := listener.Shutdown(ctx); err != nil {
+				t.Fatalf("Shutdown error: %v", err)
+			}
+			select {
+			case err := <-errCh:
+				t.Fatalf("Unexpected error reported on shutdown: %v", err)
+			default:
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package testing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+// writeCertificate creates a certificate signed by parent, or self-signed when parent is nil, and writes it and its key as PEM files in dir.
+func writeCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return cert, key
+}
+
+// writeTestPKI writes a CA, a server certificate for 127.0.0.1 and a client certificate for "camera-7" into a temporary directory.
+func writeTestPKI(t *testing.T) string {
+	dir := t.TempDir()
+	expiry := time.Now().Add(time.Hour)
+
+	ca, caKey := writeCertificate(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotAfter:              expiry,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCertificate(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotAfter:     expiry,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "camera-7"},
+		NotAfter:     expiry,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+	return dir
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := writeTestPKI(t)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, err := networktool.NewServerTLSConfig(file("server.crt"), file("server.key"), file("ca.crt"))
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig error: %v", err)
+	}
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		return networktool.GenerateRawRequest([]byte(networktool.PeerCertificate(ctx).Subject.CommonName), 2)
+	})
+	listener, err := networktool.Create_TCP_Listener_With_Router(0, router, networktool.WithTLS(serverConfig))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := "127.0.0.1:" + portString(listener.Addr())
+
+	clientConfig, err := networktool.NewClientTLSConfig(file("ca.crt"), file("client.crt"), file("client.key"))
+	if err != nil {
+		t.Fatalf("NewClientTLSConfig error: %v", err)
+	}
+	req, _ := networktool.GenerateRequest(nil, 1)
+	data, err := networktool.Handle_Single_TLS_Exchange(addr, req, 1024, clientConfig)
+	if err != nil {
+		t.Fatalf("Handle_Single_TLS_Exchange error: %v", err)
+	}
+	reply, _ := networktool.DeserialiseRequest(data)
+	if string(reply.Payload) != "camera-7" {
+		t.Fatalf("Expected the handler to see camera-7, got %q", reply.Payload)
+	}
+
+	client, err := networktool.Dial_TLS_Client(addr, clientConfig)
+	if err != nil {
+		t.Fatalf("Dial_TLS_Client error: %v", err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.Call(ctx, req); err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+
+	// Without a client certificate the listener hangs up on us.
+	anonymous, _ := networktool.NewClientTLSConfig(file("ca.crt"), "", "")
+	if _, err := networktool.Handle_Single_TLS_Exchange(addr, req, 1024, anonymous); err == nil {
+		t.Fatal("Expected an exchange without a client certificate to fail")
+	}
+
+	// Plain TCP doesn't get through either.
+	if _, err := networktool.Handle_Single_TCP_Exchange(addr, req, 1024); err == nil {
+		t.Fatal("Expected a plaintext exchange to fail")
+	}
+}
+
+func TestTLSChannelPeer(t *testing.T) {
+	dir := writeTestPKI(t)
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, _ := networktool.NewServerTLSConfig(file("server.crt"), file("server.key"), file("ca.crt"))
+	requestChannel, listener, err := networktool.Create_TCP_Listener(0, networktool.WithTLS(serverConfig))
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := "127.0.0.1:" + portString(listener.Addr())
+
+	clientConfig, _ := networktool.NewClientTLSConfig(file("ca.crt"), file("client.crt"), file("client.key"))
+	req, _ := networktool.GenerateRequest(nil, 1)
+	conn, err := networktool.SendInitialTLS(addr, req, clientConfig)
+	if err != nil {
+		t.Fatalf("SendInitialTLS error: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case data := <-requestChannel:
+		if data.Peer == nil || data.Peer.Subject.CommonName != "camera-7" {
+			t.Fatalf("Unexpected peer certificate %v", data.Peer)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the request")
+	}
+}
+
+func portString(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
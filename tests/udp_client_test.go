@@ -0,0 +1,84 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestUDPExchangeRetransmits(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+
+	router := networktool.NewRouter()
+	router.Handle(1, func(ctx context.Context, req networktool.Request_Type, addr net.Addr) ([]byte, error) {
+		// Ignore the first copy of every request, as if it had been lost on the way.
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen[string(req.Payload)] {
+			seen[string(req.Payload)] = true
+			return nil, nil
+		}
+		return networktool.GenerateRawRequest(req.Payload, 1)
+	})
+	listener, err := networktool.Create_UDP_Listener_With_Router(0, router)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	req, _ := networktool.GenerateRequest(&BasicProto{Name: []byte("tested")}, 1)
+	data, err := networktool.Handle_Single_UDP_Exchange(addr, req)
+	if err != nil {
+		t.Fatalf("Handle_Single_UDP_Exchange error: %v", err)
+	}
+	reply, err := networktool.DeserialiseRequest(data)
+	if err != nil {
+		t.Fatalf("DeserialiseRequest error: %v", err)
+	}
+	var b BasicProto
+	if err := networktool.DeserialiseData(&b, reply.Payload); err != nil || string(b.Name) != "tested" {
+		t.Fatalf("Unexpected reply %q: %v", b.Name, err)
+	}
+
+	client, err := networktool.Dial_UDP_Client(addr, networktool.RetryPolicy{Attempts: 3, Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, _ = networktool.GenerateRequest(&BasicProto{Name: []byte("again")}, 1)
+	if _, err := client.Call(ctx, req); err != nil {
+		t.Fatalf("Call error: %v", err)
+	}
+}
+
+func TestUDPClientGivesUp(t *testing.T) {
+	// Nobody reads from the request channel, so no reply ever comes back.
+	_, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	defer listener.Stop()
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+
+	client, err := networktool.Dial_UDP_Client(addr, networktool.RetryPolicy{Attempts: 3, Timeout: 10 * time.Millisecond, MaxTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Dial_UDP_Client error: %v", err)
+	}
+	defer client.Close()
+
+	req, _ := networktool.GenerateRequest(nil, 1)
+	if _, err := client.Call(context.Background(), req); !errors.Is(err, networktool.ErrNoReply) {
+		t.Fatalf("Expected ErrNoReply, got %v", err)
+	}
+}
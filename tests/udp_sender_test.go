@@ -0,0 +1,102 @@
+package testing
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	networktool "github.com/DiarmuidMalanaphy/networktools"
+)
+
+func TestUDPSender(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+	defer listener.Stop()
+
+	sender, err := networktool.NewUDPSender("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+
+	// Two SendUDP calls at once used to fight over the same local port.
+	req, _ := networktool.GenerateRequest(nil, 7)
+	errs := make(chan error, 2)
+	go func() { errs <- networktool.SendUDP(addr, req) }()
+	go func() { errs <- networktool.SendUDP(addr, req) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("SendUDP error: %v", err)
+		}
+	}
+
+	if err := sender.Send(addr, req); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	fromSender := false
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-requestChannel:
+			if data.Addr.(*net.UDPAddr).Port == sender.LocalAddr().(*net.UDPAddr).Port {
+				fromSender = true
+			}
+		case <-timeout:
+			t.Fatalf("Timed out after %d of 3 requests", i)
+		}
+	}
+	if !fromSender {
+		t.Fatal("No request arrived from the sender's local address")
+	}
+}
+
+func TestUDPSenderLocalAddress(t *testing.T) {
+	requestChannel, listener, err := networktool.Create_UDP_Listener(0)
+	if err != nil {
+		t.Fatalf("Error creating listener: %v", err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", listener.Addr().(*net.UDPAddr).Port)
+	defer listener.Stop()
+
+	// Find a free port to ask for.
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	local := fmt.Sprintf("127.0.0.1:%d", port)
+	sender, err := networktool.NewUDPSender(local)
+	if err != nil {
+		t.Fatalf("NewUDPSender error: %v", err)
+	}
+	defer sender.Close()
+	if got := sender.LocalAddr().String(); got != local {
+		t.Fatalf("Expected the sender to be bound to %s, got %s", local, got)
+	}
+
+	// The port is now taken, so a second sender can't have it.
+	if second, err := networktool.NewUDPSender(local); err == nil {
+		second.Close()
+		t.Fatal("Expected binding an address already in use to fail")
+	}
+
+	req, _ := networktool.GenerateRequest(nil, 7)
+	if err := sender.Send(addr, req); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	select {
+	case data := <-requestChannel:
+		if data.Addr.(*net.UDPAddr).Port != port {
+			t.Fatalf("Expected the request from port %d, got %s", port, data.Addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The request never arrived")
+	}
+}
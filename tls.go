@@ -0,0 +1,198 @@
+package networktools
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+)
+
+// NewServerTLSConfig builds the TLS configuration for a TCP listener from PEM files, ready to pass to WithTLS.
+// If clientCAFile is given, clients have to present a certificate signed by one of the CAs in it (mutual TLS),
+// and the certificate they presented is available as TCPNetworkData.Peer or PeerCertificate inside a handler.
+//
+// Example:
+//
+//	config, err := networktools.NewServerTLSConfig("server.crt", "server.key", "clients-ca.crt")
+//	if err != nil {
+//		return err
+//	}
+//	request_channel, listener, err := Create_TCP_Listener(8080, networktools.WithTLS(config))
+func NewServerTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// NewClientTLSConfig builds the TLS configuration for connecting to a TLS listener from PEM files.
+// The server's certificate is checked against the CAs in caFile, or the system's CAs if caFile is empty.
+// certFile and keyFile are the client's own certificate for listeners that require one, leave them empty otherwise.
+//
+// Example:
+//
+//	config, err := networktools.NewClientTLSConfig("server-ca.crt", "camera.crt", "camera.key")
+//	if err != nil {
+//		return err
+//	}
+//	data, err := networktools.Handle_Single_TLS_Exchange("cameras.example.com:5057", req, 1024, config)
+func NewClientTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// SendInitialTLS works the same as SendInitialTCP but connects over TLS with the given configuration.
+// The handshake is complete by the time the function returns, so a certificate problem is reported here rather than on the first read.
+//
+// Example:
+//
+//	conn, err := SendInitialTLS(target_addr, data, config)
+//	if err != nil {
+//		return nil, fmt.Errorf("error in SendInitialTLS: %w", err)
+//	}
+//	defer conn.Close()
+func SendInitialTLS(target_address string, data []byte, config *tls.Config) (net.Conn, error) {
+	return SendInitialTLSContext(context.Background(), target_address, data, config)
+}
+
+// SendInitialTLSContext works the same as SendInitialTLS but the context bounds dialing, the handshake and sending the data.
+func SendInitialTLSContext(ctx context.Context, target_address string, data []byte, config *tls.Config) (net.Conn, error) {
+	conn, err := dialTLS(ctx, target_address, config)
+	if err != nil {
+		return nil, err
+	}
+	return sendInitial(ctx, conn, data)
+}
+
+// Handle_Single_TLS_Exchange works the same as Handle_Single_TCP_Exchange but connects over TLS with the given configuration.
+//
+// Example:
+//
+//	(Purposefully excluded error handling)
+//	config, _ := networktools.NewClientTLSConfig("server-ca.crt", "", "")
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	data, _ := networktools.Handle_Single_TLS_Exchange("192.168.1.76:5057", req, 1024, config)
+func Handle_Single_TLS_Exchange(target_addr string, data []byte, buff_size uint32, config *tls.Config) ([]byte, error) {
+	return Handle_Single_TLS_Exchange_Context(context.Background(), target_addr, data, buff_size, config)
+}
+
+// Handle_Single_TLS_Exchange_Context works the same as Handle_Single_TLS_Exchange but gives up once the context is cancelled or its deadline passes.
+func Handle_Single_TLS_Exchange_Context(ctx context.Context, target_addr string, data []byte, buff_size uint32, config *tls.Config) ([]byte, error) {
+	conn, err := SendInitialTLSContext(ctx, target_addr, data, config)
+	if err != nil {
+		return nil, fmt.Errorf("error in SendInitialTLS: %w", err)
+	}
+	defer conn.Close()
+
+	buff, err := Get_TCP_Reply_Context(ctx, conn, buff_size)
+	if err != nil {
+		return nil, fmt.Errorf("error in Get_TCP_Reply: %w", err)
+	}
+	if err := checkReply(buff); err != nil {
+		return nil, err
+	}
+
+	return buff, nil
+}
+
+// Dial_TLS_Client works the same as Dial_Client but connects over TLS with the given configuration.
+func Dial_TLS_Client(target_address string, config *tls.Config) (*Client, error) {
+	conn, err := dialTLS(context.Background(), target_address, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+func dialTLS(ctx context.Context, target_address string, config *tls.Config) (net.Conn, error) {
+	dialer := tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", target_address)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing TLS: %w", err)
+	}
+	return conn, nil
+}
+
+// acceptTLS completes the TLS handshake on a connection accepted by a TLS listener, within the listener's read timeout.
+// It returns the client's certificate if it was verified against the configured CAs, nil if the client didn't have to present one.
+func acceptTLS(conn *tls.Conn, cfg ServerConfig) (*x509.Certificate, error) {
+	ctx, cancel := timeoutContext(cfg.ReadTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	return state.VerifiedChains[0][0], nil
+}
+
+type peerCertificateKey struct{}
+
+// PeerCertificate returns the verified certificate of whoever sent the request being handled, or nil if there isn't one.
+// There is only a certificate for requests that arrived on a TLS listener that verifies client certificates, see NewServerTLSConfig.
+//
+// Example:
+//
+//	router.Handle(RequestMoveCamera, func(ctx context.Context, req networktools.Request_Type, addr net.Addr) ([]byte, error) {
+//		cert := networktools.PeerCertificate(ctx)
+//		if cert == nil || cert.Subject.CommonName != "operator" {
+//			return nil, fmt.Errorf("not allowed to move cameras")
+//		}
+//		(code code code)
+//	})
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	cert, _ := ctx.Value(peerCertificateKey{}).(*x509.Certificate)
+	return cert
+}
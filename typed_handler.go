@@ -0,0 +1,36 @@
+package networktools
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Handle registers a typed handler on the router for a request type.
+// The payload of every request of that type is decoded into a new T before your handler is called, and the message your handler returns is encoded with GenerateRequest using the same request type.
+// Returning a nil message replies with an empty request of that type.
+// If the payload cannot be decoded into T the handler is not called and the sender gets a CodeDecodeFailed error reply, see RemoteError.
+//
+// Example:
+//
+//	networktools.Handle(router, RequestCamera, func(ctx context.Context, c *CameraProto) (proto.Message, error) {
+//		cameraMap.addCamera(c)
+//		return &CameraAck{Id: c.Id}, nil
+//	})
+func Handle[T proto.Message](router *Router, reqType uint32, handler func(ctx context.Context, req T) (proto.Message, error)) {
+	router.Handle(reqType, func(ctx context.Context, req Request_Type, addr net.Addr) ([]byte, error) {
+		var zero T
+		msg := zero.ProtoReflect().New().Interface().(T)
+
+		if err := DeserialiseData(msg, req.Payload); err != nil {
+			return nil, &RemoteError{Code: CodeDecodeFailed, Message: err.Error()}
+		}
+
+		reply, err := handler(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		return GenerateRequest(reply, reqType)
+	})
+}
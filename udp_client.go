@@ -0,0 +1,291 @@
+package networktools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoReply is returned when a UDP request was sent as many times as the RetryPolicy allows without a reply coming back.
+var ErrNoReply = errors.New("no reply received")
+
+// RetryPolicy decides how a UDPClient retransmits a request that hasn't been answered.
+// The wait for a reply starts at Timeout and doubles after every attempt, up to MaxTimeout.
+type RetryPolicy struct {
+	Attempts   int           // How many times a request is sent before giving up
+	Timeout    time.Duration // How long to wait for a reply to the first attempt
+	MaxTimeout time.Duration // The longest the wait grows to between attempts
+}
+
+// DefaultRetryPolicy sends a request up to 5 times, waiting 200ms for the first reply and at most 2 seconds for later ones.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Timeout:    200 * time.Millisecond,
+	MaxTimeout: 2 * time.Second,
+}
+
+// next returns how long to wait after the given timeout.
+func (p RetryPolicy) next(timeout time.Duration) time.Duration {
+	timeout *= 2
+	if p.MaxTimeout > 0 && timeout > p.MaxTimeout {
+		return p.MaxTimeout
+	}
+	return timeout
+}
+
+// retransmit sends a request with write and waits for answered to receive, sending the request again whenever the wait set by the policy runs out.
+// The request is split up once so every attempt reuses the same message ID, letting the listener fill in fragments lost on earlier attempts.
+// write is called afresh for every attempt, which a sealing write relies on since a listener rejects the same sealed datagram twice as a replay.
+// Once every attempt has gone unanswered it returns unanswered, saying how many attempts were made.
+func retransmit[T any](ctx context.Context, policy RetryPolicy, c *closer, data []byte, write func([]byte) error, answered <-chan T, unanswered error) (T, error) {
+	var none T
+	fragments, err := FragmentRequest(data, DefaultFragmentSize)
+	if err != nil {
+		return none, err
+	}
+	timeout := policy.Timeout
+
+	for attempt := 0; attempt < policy.Attempts; attempt++ {
+		for _, fragment := range fragments {
+			if err := write(fragment); err != nil {
+				return none, fmt.Errorf("error sending request: %w", err)
+			}
+		}
+
+		timer := time.NewTimer(timeout)
+		select {
+		case answer := <-answered:
+			timer.Stop()
+			return answer, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return none, ctx.Err()
+		case <-c.closed:
+			timer.Stop()
+			return none, c.failed()
+		case <-timer.C:
+			timeout = policy.next(timeout)
+		}
+	}
+
+	return none, fmt.Errorf("%w after %d attempts", unanswered, policy.Attempts)
+}
+
+// UDPClient sends requests to a single UDP server and waits for their replies, retransmitting requests that go unanswered.
+// Like Client every request is tagged with a correlation ID, so many calls can be waiting at once and replies can arrive in any order.
+// Because a lost reply looks the same as a lost request, the server may receive a request more than once and should be able to handle repeats.
+//
+// Example:
+//
+//	client, err := networktools.Dial_UDP_Client("192.168.1.76:5057", networktools.DefaultRetryPolicy)
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	reply, err := client.Call(ctx, req)
+type UDPClient struct {
+	conn   *net.UDPConn
+	policy RetryPolicy
+	keys   *SealKeys // Seals requests and opens replies when set
+	nextID uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan []byte
+
+	*closer
+}
+
+// Dial_UDP_Client opens a socket for talking to the target address and returns a UDPClient that makes calls over it.
+// Be aware you will have to close the client yourself.
+func Dial_UDP_Client(target_address string, policy RetryPolicy) (*UDPClient, error) {
+	return Dial_Sealed_UDP_Client(target_address, policy, nil)
+}
+
+// Dial_Sealed_UDP_Client works the same as Dial_UDP_Client but the client seals its requests with the keys and only accepts replies sealed with them, for a listener created with WithSealKeys.
+func Dial_Sealed_UDP_Client(target_address string, policy RetryPolicy, keys *SealKeys) (*UDPClient, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", target_address)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving address: %w", err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing UDP: %w", err)
+	}
+	conn.SetReadBuffer(udpReadBuffer)
+
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+
+	c := &UDPClient{
+		conn:    conn,
+		policy:  policy,
+		keys:    keys,
+		pending: make(map[uint64]chan []byte),
+		closer:  newCloser(),
+	}
+	go c.readReplies()
+	return c, nil
+}
+
+// Call sends a request generated with GenerateRequest and waits for its reply, sending it again whenever the wait runs out.
+// It returns ErrNoReply once every attempt has gone unanswered, or the context's error if the context ends first.
+// Headers carried by the context are added to the request, see ContextWithHeaders.
+// An error reply is returned as a *RemoteError.
+func (c *UDPClient) Call(ctx context.Context, data []byte) (Request_Type, error) {
+	raw, err := c.call(ctx, data)
+	if err != nil {
+		return Request_Type{}, err
+	}
+	reply, err := DeserialiseRequest(raw)
+	if err != nil {
+		return Request_Type{}, err
+	}
+	if err := ReplyError(reply); err != nil {
+		return Request_Type{}, err
+	}
+	return reply, nil
+}
+
+func (c *UDPClient) call(ctx context.Context, data []byte) ([]byte, error) {
+	id := atomic.AddUint64(&c.nextID, 1)
+	replyCh := make(chan []byte, 1)
+
+	if err := c.failed(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.pending[id] = replyCh
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	write := sealedWrite(c.keys, sealRequest, func(datagram []byte) error {
+		_, err := c.conn.Write(datagram)
+		return err
+	})
+	return retransmit(ctx, c.policy, c.closer, setCorrelationID(addHeaders(data, ContextHeaders(ctx)), id), write, replyCh, ErrNoReply)
+}
+
+// Close closes the socket and fails every call that is still waiting for a reply with ErrClientClosed.
+func (c *UDPClient) Close() error {
+	c.fail(ErrClientClosed)
+	return c.conn.Close()
+}
+
+func (c *UDPClient) readReplies() {
+	buffer := make([]byte, maxUDPDatagramSize)
+	fragments := newReassembler(replyTimeout, 0, DefaultMaxMessageSize)
+
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			// A refused datagram shows up as a read error on a connected socket, the retransmissions deal with it.
+			if netErr, ok := err.(net.Error); ok && !netErr.Timeout() && !errors.Is(err, net.ErrClosed) {
+				defaultLogger().Debug("Error reading reply", "remote", c.conn.RemoteAddr(), "error", err)
+				continue
+			}
+			c.fail(fmt.Errorf("%w: %v", ErrClientClosed, err))
+			return
+		}
+
+		raw := buffer[:n]
+		if c.keys != nil {
+			if raw, err = c.keys.OpenReply(raw); err != nil {
+				defaultLogger().Warn("Dropping reply that could not be unsealed", "remote", c.conn.RemoteAddr(), "bytes", n, "error", err)
+				continue
+			}
+		}
+
+		reply, err := DeserialiseRequest(raw)
+		if err == nil && reply.fragmentCount != 0 {
+			now := time.Now()
+			fragments.sweep(now)
+			raw, err = fragments.add(c.conn.RemoteAddr(), reply, now)
+			if err == nil && raw == nil {
+				// Still waiting for the rest of the fragments.
+				continue
+			}
+			if err == nil {
+				reply, err = DeserialiseRequest(raw)
+			}
+		}
+		if err != nil {
+			defaultLogger().Warn("Error deserialising reply", "remote", c.conn.RemoteAddr(), "bytes", len(raw), "error", err)
+			continue
+		}
+
+		c.mu.Lock()
+		replyCh, ok := c.pending[reply.CorrelationID]
+		c.mu.Unlock()
+		if ok {
+			// raw may still be the read buffer, which the next read overwrites.
+			raw = append([]byte(nil), raw...)
+
+			// The channel holds one reply, anything beyond that for the same ID is a duplicate and is dropped.
+			select {
+			case replyCh <- raw:
+			default:
+			}
+		}
+	}
+}
+
+// Handle_Single_UDP_Exchange sends a single request over UDP and waits for the reply, retransmitting it according to DefaultRetryPolicy.
+// It is the UDP counterpart of Handle_Single_TCP_Exchange and, like it, returns the raw reply for you to deserialise, or a *RemoteError if the reply is an error.
+//
+// Example:
+//
+//	(Purposefully excluded error handling)
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	data, _ := networktools.Handle_Single_UDP_Exchange("192.168.1.76:5057", req)
+//	reply, _ := networktools.DeserialiseRequest(data)
+func Handle_Single_UDP_Exchange(target_addr string, data []byte) ([]byte, error) {
+	return Handle_Single_UDP_Exchange_Context(context.Background(), target_addr, data)
+}
+
+// Handle_Single_UDP_Exchange_Context works the same as Handle_Single_UDP_Exchange but gives up once the context is cancelled or its deadline passes.
+func Handle_Single_UDP_Exchange_Context(ctx context.Context, target_addr string, data []byte) ([]byte, error) {
+	return Handle_Single_Sealed_UDP_Exchange_Context(ctx, target_addr, data, nil)
+}
+
+// Handle_Single_Sealed_UDP_Exchange works the same as Handle_Single_UDP_Exchange but seals the request with the keys and only accepts a reply sealed with them.
+//
+// Example:
+//
+//	(Purposefully excluded error handling)
+//	keys, _ := networktools.NewSealKeys(1, map[uint32][]byte{1: key})
+//	req, _ := networktools.GenerateRequest(garb, 14)
+//	data, _ := networktools.Handle_Single_Sealed_UDP_Exchange("192.168.1.76:5057", req, keys)
+func Handle_Single_Sealed_UDP_Exchange(target_addr string, data []byte, keys *SealKeys) ([]byte, error) {
+	return Handle_Single_Sealed_UDP_Exchange_Context(context.Background(), target_addr, data, keys)
+}
+
+// Handle_Single_Sealed_UDP_Exchange_Context works the same as Handle_Single_Sealed_UDP_Exchange but gives up once the context is cancelled or its deadline passes.
+func Handle_Single_Sealed_UDP_Exchange_Context(ctx context.Context, target_addr string, data []byte, keys *SealKeys) ([]byte, error) {
+	client, err := Dial_Sealed_UDP_Client(target_addr, DefaultRetryPolicy, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	raw, err := client.call(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkReply(raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
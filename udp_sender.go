@@ -0,0 +1,74 @@
+package networktools
+
+import (
+	"fmt"
+	"net"
+)
+
+// UDPSender keeps a single UDP socket open and sends every request through it, which saves opening a socket per send like SendUDP does.
+// A UDPSender is safe to use from several goroutines at once.
+//
+// Example:
+//
+//	sender, err := networktools.NewUDPSender("")
+//	if err != nil {
+//		return err
+//	}
+//	defer sender.Close()
+//
+//	for _, camera := range cameras {
+//		req, _ := networktools.GenerateRequest(camera, RequestUpdate)
+//		sender.Send(camera.Addr, req)
+//	}
+type UDPSender struct {
+	conn *net.UDPConn
+	keys *SealKeys // Seals every datagram when set
+}
+
+// NewUDPSender opens a socket bound to the local address, for example ":8000" to send from port 8000.
+// An empty local address lets the system choose a free port.
+func NewUDPSender(local_address string) (*UDPSender, error) {
+	return NewSealedUDPSender(local_address, nil)
+}
+
+// NewSealedUDPSender works the same as NewUDPSender but the sender seals every datagram with the keys, for a listener created with WithSealKeys.
+func NewSealedUDPSender(local_address string, keys *SealKeys) (*UDPSender, error) {
+	var localAddr *net.UDPAddr
+	if local_address != "" {
+		var err error
+		localAddr, err = net.ResolveUDPAddr("udp", local_address)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving local address: %w", err)
+		}
+	}
+
+	conn, err := net.ListenUDP("udp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error opening UDP socket: %w", err)
+	}
+
+	return &UDPSender{conn: conn, keys: keys}, nil
+}
+
+// Send transmits the data to the target address as a single datagram, or as fragments if it is bigger than DefaultFragmentSize.
+func (s *UDPSender) Send(target_address string, data []byte) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", target_address)
+	if err != nil {
+		return err
+	}
+
+	return writeFragmented(data, sealedWrite(s.keys, sealRequest, func(datagram []byte) error {
+		_, err := s.conn.WriteToUDP(datagram, udpAddr)
+		return err
+	}))
+}
+
+// LocalAddr returns the address the sender's socket is bound to, which is where replies will be sent.
+func (s *UDPSender) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close closes the socket. The sender can't be used afterwards.
+func (s *UDPSender) Close() error {
+	return s.conn.Close()
+}